@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
+	"github.com/slatunje/aws-cwa-metric/pkg/config"
 	"github.com/slatunje/aws-cwa-metric/pkg/metric"
+	"github.com/slatunje/aws-cwa-metric/pkg/service"
 	"github.com/slatunje/aws-cwa-metric/pkg/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -19,16 +22,50 @@ const (
 )
 
 var (
-	region    string
-	namespace string
-	interval  int
-	once      bool
-	memory    bool
-	swap      bool
-	cpu       bool
-	disk      bool
-	network   bool
-	docker    bool
+	settings   = &config.File{}
+	configFile string
+	region     string
+	namespace  string
+	interval   string
+	once       bool
+	align      bool
+	flush      time.Duration
+	counters   string
+	counter    []string
+	identity   string
+	tokenTTL   time.Duration
+	imdsV1     bool
+	tagsTTL    time.Duration
+	spoolDir   string
+	spoolSize  int
+	spoolAge   time.Duration
+	maxElapsed time.Duration
+	maxTPS     float64
+	agent      bool
+	memory     bool
+	swap       bool
+	cpu        bool
+	perCore    bool
+	totalCPU   bool
+	disk       bool
+	diskIO     bool
+	network    bool
+	perIface   bool
+	totalNet   bool
+	docker     bool
+	backend    string
+	socket     string
+	labels     []string
+	ecs        bool
+	k8s        bool
+	kubelet    string
+	insecure   bool
+	cluster    string
+	procstat   bool
+	procExe    []string
+	system     bool
+	netstat    bool
+	ports      []int
 )
 
 // rootCmd represents the base command when called without any sub commands
@@ -40,7 +77,7 @@ Description:
   %s sends aws metric to cloud watch.
 `, app),
 	Run: func(cmd *cobra.Command, args []string) {
-		metric.Execute()
+		metric.Execute(settings)
 	},
 }
 
@@ -50,27 +87,93 @@ func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.Version = version
 	// === settings === //
+	rootCmd.PersistentFlags().
+		StringVar(&configFile, "config", "", "set json or yaml configuration file, compatible with the cloud watch agent metrics section.")
 	rootCmd.PersistentFlags().
 		StringVar(&region, "region", utils.CWARegion, "set aws region value.")
 	rootCmd.PersistentFlags().
 		StringVar(&namespace, "namespace", utils.CWANamespace, "set metric label.")
 	rootCmd.PersistentFlags().
-		IntVarP(&interval, "interval", "i", utils.CWAInterval, "set time interval value.")
+		StringVarP(&interval, "interval", "i", utils.CWAInterval, "set time interval value. (i.e. 10s, 1m, 5m or a number of minutes)")
+	rootCmd.PersistentFlags().
+		StringVar(&identity, "identity", utils.CWAIdentity, "set how the host is identified. (i.e. auto, ec2, static or host)")
+	rootCmd.PersistentFlags().
+		DurationVar(&tokenTTL, "imds-token-ttl", service.MetaDataTokenTTL, "set time to live of the instance metadata session token.")
+	rootCmd.PersistentFlags().
+		BoolVar(&imdsV1, "imds-v1", false, "fall back to instance metadata v1 when no session token is issued.")
+	rootCmd.PersistentFlags().
+		DurationVar(&tagsTTL, "tags-refresh", service.TagsRefresh, "set time between two reads of the instance tags used as dimensions.")
+	rootCmd.PersistentFlags().
+		StringVar(&spoolDir, "spool-dir", utils.CWASpoolDir, "set directory keeping metrics that could not be sent. (empty to disable)")
+	rootCmd.PersistentFlags().
+		IntVar(&spoolSize, "spool-size", utils.CWASpoolSize, "set maximum size of the spool directory in megabytes.")
+	rootCmd.PersistentFlags().
+		DurationVar(&spoolAge, "spool-max-age", service.SpoolMaxAge, "set maximum age of spooled metrics, at most two weeks.")
+	rootCmd.PersistentFlags().
+		DurationVar(&maxElapsed, "retry-max-elapsed", service.RetryMaxElapsed, "set time after which a failing batch is spooled or dropped.")
+	rootCmd.PersistentFlags().
+		Float64Var(&maxTPS, "max-tps", service.RetryMaxTPS, "set maximum requests per second sent to cloud watch. (0 for no limit)")
 	rootCmd.PersistentFlags().
 		BoolVarP(&once, "once", "o", false, "execute once and stop. (i.e. never repeat.")
+	rootCmd.PersistentFlags().
+		BoolVar(&align, "align", false, "align collections and timestamps to the interval boundary. (i.e. :00, :05)")
+	rootCmd.PersistentFlags().
+		DurationVar(&flush, "flush-interval", 0, "aggregate samples into statistic sets sent at this interval. (i.e. 1m)")
+	rootCmd.PersistentFlags().
+		StringVar(&counters, "counters", utils.CWACounters, "publish counters as raw, delta, rate or a combination (i.e. delta+rate).")
+	rootCmd.PersistentFlags().
+		StringSliceVar(&counter, "counter", nil, "publish a counter in its own mode. (i.e. net_bytes_recv=raw+rate)")
 	// === metrics === //
 	rootCmd.PersistentFlags().
-		BoolVarP(&disk, metric.KeyCPU, "c", false, "collect cpu metrics.")
+		BoolVar(&agent, metric.KeyAgent, false, "collect agent self metrics.")
+	rootCmd.PersistentFlags().
+		BoolVarP(&cpu, metric.KeyCPU, "c", false, "collect cpu metrics.")
+	rootCmd.PersistentFlags().
+		BoolVar(&perCore, "cpu-percore", false, "collect cpu metrics per core.")
+	rootCmd.PersistentFlags().
+		BoolVar(&totalCPU, "cpu-total", true, "collect cpu metrics across all cores.")
 	rootCmd.PersistentFlags().
 		BoolVarP(&disk, metric.KeyDisk, "d", false, "collect disk metrics.")
+	rootCmd.PersistentFlags().
+		BoolVar(&diskIO, metric.KeyDiskIO, false, "collect disk io metrics.")
 	rootCmd.PersistentFlags().
 		BoolVar(&docker, metric.KeyDocker, false, "collect docker container metrics.")
+	rootCmd.PersistentFlags().
+		StringVar(&backend, "docker-backend", metric.DockerBackendCGroup, "set where container metrics are read from. (i.e. cgroup or api)")
+	rootCmd.PersistentFlags().
+		StringVar(&socket, "docker-socket", service.DockerSocket, "set the unix socket of the docker engine api.")
+	rootCmd.PersistentFlags().
+		StringSliceVar(&labels, "docker-labels", nil, "publish container labels as dimensions. (i.e. com.docker.compose.service)")
+	rootCmd.PersistentFlags().
+		BoolVar(&ecs, metric.KeyECS, false, "collect ecs task and container metrics from the task metadata endpoint.")
+	rootCmd.PersistentFlags().
+		BoolVar(&k8s, metric.KeyK8s, false, "collect kubernetes pod and container metrics from the kubelet summary api.")
+	rootCmd.PersistentFlags().
+		StringVar(&kubelet, "kubelet", "", "set the endpoint of the kubelet, https://${HOST_IP}:10250 or https://${NODE_NAME}:10250 when not set.")
+	rootCmd.PersistentFlags().
+		BoolVar(&insecure, "kubelet-insecure", false, "skip the verification of the kubelet serving certificate.")
+	rootCmd.PersistentFlags().
+		StringVar(&cluster, "cluster-name", "", "set the ClusterName dimension of kubernetes metrics, read from the instance tags when not set.")
 	rootCmd.PersistentFlags().
 		BoolVarP(&memory, metric.KeyMemory, "m", false, "collect memory metrics.")
+	rootCmd.PersistentFlags().
+		BoolVar(&netstat, metric.KeyNetstat, false, "collect the number of tcp sockets per state and of udp sockets.")
+	rootCmd.PersistentFlags().
+		IntSliceVar(&ports, "netstat-ports", nil, "also count the sockets of these local ports on their own. (i.e. 80,443)")
 	rootCmd.PersistentFlags().
 		BoolVarP(&network, metric.KeyNetwork, "n", false, "collect network metrics.")
+	rootCmd.PersistentFlags().
+		BoolVar(&perIface, "net-per-interface", true, "collect network metrics per interface.")
+	rootCmd.PersistentFlags().
+		BoolVar(&totalNet, "net-total", false, "collect network metrics across the selected interfaces.")
+	rootCmd.PersistentFlags().
+		BoolVar(&procstat, metric.KeyProcstat, false, "collect process metrics of the groups of the config file and of --procstat-exe.")
+	rootCmd.PersistentFlags().
+		StringSliceVar(&procExe, "procstat-exe", nil, "collect process metrics of the processes whose name matches. (i.e. nginx,sshd)")
 	rootCmd.PersistentFlags().
 		BoolVarP(&swap, metric.KeySwap, "s", false, "collect swap metrics.")
+	rootCmd.PersistentFlags().
+		BoolVar(&system, metric.KeySystem, false, "collect system load, uptime, users and process metrics.")
 }
 
 // setEmptyTimezone
@@ -83,6 +186,16 @@ func setEmptyTimezone() {
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	setDefaults()
+	if configFile == "" {
+		return
+	}
+	f, err := config.Load(configFile)
+	if err != nil {
+		log.Println(err)
+		os.Exit(utils.ExitConfigFailure)
+	}
+	settings = f
+	setFileDefaults()
 }
 
 // setDefaults
@@ -92,12 +205,65 @@ func setDefaults() {
 	viper.SetDefault(utils.CWANamespaceKey, namespace)
 	viper.SetDefault(utils.CWAIntervalKey, interval)
 	viper.SetDefault(utils.CWAOnceKey, once)
+	viper.SetDefault(utils.CWAAlignKey, align)
+	viper.SetDefault(utils.CWAFlushIntervalKey, flush)
+	viper.SetDefault(utils.CWAIdentityKey, identity)
+	viper.SetDefault(utils.CWAIMDSTokenTTLKey, tokenTTL)
+	viper.SetDefault(utils.CWAIMDSv1Key, imdsV1)
+	viper.SetDefault(utils.CWATagsRefreshKey, tagsTTL)
+	viper.SetDefault(utils.CWASpoolDirKey, spoolDir)
+	viper.SetDefault(utils.CWASpoolSizeKey, spoolSize)
+	viper.SetDefault(utils.CWASpoolMaxAgeKey, spoolAge)
+	viper.SetDefault(utils.CWARetryMaxElapsedKey, maxElapsed)
+	viper.SetDefault(utils.CWAMaxTPSKey, maxTPS)
+	viper.SetDefault(utils.CWACountersKey, counters)
+	viper.SetDefault(utils.CWACounterKey, counter)
+	viper.SetDefault("aws_metrics_agent", agent)
 	viper.SetDefault("aws_metrics_cpu", cpu)
+	viper.SetDefault(utils.CWACPUPerCoreKey, perCore)
+	viper.SetDefault(utils.CWACPUTotalKey, totalCPU)
+	viper.SetDefault(utils.CWANetPerInterfaceKey, perIface)
+	viper.SetDefault(utils.CWANetTotalKey, totalNet)
+	viper.SetDefault(utils.CWADockerBackendKey, backend)
+	viper.SetDefault(utils.CWADockerSocketKey, socket)
+	viper.SetDefault(utils.CWADockerLabelsKey, labels)
 	viper.SetDefault("aws_metrics_memory", memory)
 	viper.SetDefault("aws_metrics_swap", swap)
+	viper.SetDefault("aws_metrics_system", system)
 	viper.SetDefault("aws_metrics_disk", disk)
+	viper.SetDefault("aws_metrics_diskio", diskIO)
 	viper.SetDefault("aws_metrics_network", network)
+	viper.SetDefault("aws_metrics_netstat", netstat)
+	viper.SetDefault(utils.CWANetstatPortsKey, ports)
 	viper.SetDefault("aws_metrics_docker", docker)
+	viper.SetDefault("aws_metrics_ecs", ecs)
+	viper.SetDefault("aws_metrics_kubernetes", k8s)
+	viper.SetDefault("aws_metrics_procstat", procstat || len(procExe) > 0)
+	viper.SetDefault(utils.CWAProcstatExeKey, procExe)
+	viper.SetDefault(utils.CWAKubeletKey, kubelet)
+	viper.SetDefault(utils.CWAKubeletInsecureKey, insecure)
+	viper.SetDefault(utils.CWAClusterNameKey, cluster)
+}
+
+// setFileDefaults lets the config file replace the defaults of flags that were not set
+func setFileDefaults() {
+	var set = func(flag, key string, value interface{}) {
+		if !rootCmd.PersistentFlags().Changed(flag) {
+			viper.SetDefault(key, value)
+		}
+	}
+	if settings.Agent.Region != "" {
+		set("region", utils.CWARegionKey, settings.Agent.Region)
+	}
+	if settings.Metrics.Namespace != "" {
+		set("namespace", utils.CWANamespaceKey, settings.Metrics.Namespace)
+	}
+	if settings.Metrics.FlushInterval > 0 {
+		set("flush-interval", utils.CWAFlushIntervalKey, time.Duration(settings.Metrics.FlushInterval)*time.Second)
+	}
+	if settings.Agent.Interval > 0 {
+		set("interval", utils.CWAIntervalKey, fmt.Sprintf("%ds", settings.Agent.Interval))
+	}
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
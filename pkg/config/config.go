@@ -0,0 +1,225 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// File is the agent configuration, modelled on the CloudWatch Agent configuration file
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch-Agent-Configuration-File-Details.html
+type File struct {
+	Agent   Agent   `json:"agent"`
+	Metrics Metrics `json:"metrics"`
+}
+
+// Agent holds the settings shared by all plugins
+type Agent struct {
+	Interval int      `json:"metrics_collection_interval"`
+	Region   string   `json:"region"`
+	Identity Identity `json:"identity"`
+}
+
+// Identity holds the values used when the identity of the host is static
+type Identity struct {
+	Host         string `json:"host"`
+	InstanceID   string `json:"instance_id"`
+	ImageID      string `json:"image_id"`
+	InstanceType string `json:"instance_type"`
+	Region       string `json:"region"`
+}
+
+// Metrics holds the `metrics` section
+type Metrics struct {
+	Namespace             string            `json:"namespace"`
+	FlushInterval         int               `json:"force_flush_interval"`
+	AppendDimensions      map[string]string `json:"append_dimensions"`
+	AggregationDimensions [][]string        `json:"aggregation_dimensions"`
+	Collected             Collected         `json:"metrics_collected"`
+}
+
+// Collected holds one entry per plugin, a plugin left out is not collected
+type Collected struct {
+	CPU    *Plugin `json:"cpu"`
+	Disk   *Plugin `json:"disk"`
+	DiskIO *Plugin `json:"diskio"`
+	Mem    *Plugin `json:"mem"`
+	Swap   *Plugin `json:"swap"`
+	Net    *Plugin `json:"net"`
+	Docker *Plugin `json:"docker"`
+	ECS    *Plugin `json:"ecs"`
+
+	Kubernetes *Plugin `json:"kubernetes"`
+	Procstat   *Plugin `json:"procstat"`
+	System     *Plugin `json:"system"`
+	Netstat    *Plugin `json:"netstat"`
+}
+
+// Plugin holds the settings of a single plugin
+type Plugin struct {
+	Measurement      []Measurement     `json:"measurement"`
+	Resources        []string          `json:"resources"`
+	Interval         int               `json:"metrics_collection_interval"`
+	AppendDimensions map[string]string `json:"append_dimensions"`
+	HighResolution   *bool             `json:"high_resolution"`
+	TotalCPU         *bool             `json:"totalcpu"`
+
+	// disk filters, patterns are globs or regular expressions prefixed with `re:`,
+	// leaving out an ignore list keeps its defaults while an empty list clears them
+	MountPoints           []string `json:"mount_points"`
+	IgnoreMountPoints     []string `json:"ignore_mount_points"`
+	Devices               []string `json:"devices"`
+	IgnoreDevices         []string `json:"ignore_devices"`
+	FileSystemTypes       []string `json:"file_system_types"`
+	IgnoreFileSystemTypes []string `json:"ignore_file_system_types"`
+	DropDevice            bool     `json:"drop_device"`
+	DropFileSystemType    bool     `json:"drop_fstype"`
+
+	// network filters and series, an interface is selected by name
+	Interfaces       []string `json:"interfaces"`
+	IgnoreInterfaces []string `json:"ignore_interfaces"`
+	PerInterface     *bool    `json:"per_interface"`
+	TotalNet         *bool    `json:"totalnet"`
+
+	// docker backend and the container labels published as dimensions
+	Backend         string   `json:"backend"`
+	ContainerLabels []string `json:"container_labels"`
+
+	// procstat groups of processes
+	Processes []Process `json:"processes"`
+
+	// netstat local ports whose sockets are also counted on their own
+	Ports []int `json:"ports"`
+}
+
+// UnmarshalJSON accepts either an object or, as the procstat plugin of the CloudWatch Agent is given,
+// an array of processes
+func (p *Plugin) UnmarshalJSON(b []byte) error {
+	var processes []Process
+	if err := json.Unmarshal(b, &processes); err == nil {
+		*p = Plugin{Processes: processes}
+		return nil
+	}
+	type plugin Plugin
+	return json.Unmarshal(b, (*plugin)(p))
+}
+
+// Process selects a group of processes by one of the name of their executable, a regular expression
+// matching their command line, a pid file or the systemd unit they belong to.
+// Measurement selects the metrics of the group, all of them when left out.
+type Process struct {
+	Exe         string        `json:"exe"`
+	Pattern     string        `json:"pattern"`
+	PidFile     string        `json:"pid_file"`
+	SystemdUnit string        `json:"systemd_unit"`
+	Measurement []Measurement `json:"measurement"`
+}
+
+// Measurement selects a metric and optionally renames it, changes its unit or aggregates it
+// as a distribution of values and counts between flushes
+type Measurement struct {
+	Name         string `json:"name"`
+	Rename       string `json:"rename"`
+	Unit         string `json:"unit"`
+	Distribution bool   `json:"distribution"`
+}
+
+// UnmarshalJSON accepts either a metric name or an object
+func (m *Measurement) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*m = Measurement{Name: name}
+		return nil
+	}
+	type measurement Measurement
+	return json.Unmarshal(b, (*measurement)(m))
+}
+
+// Load reads a json or yaml configuration file, the format is chosen by extension
+func Load(path string) (*File, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		if b, err = yamlToJSON(b); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	var f File
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if err := f.Metrics.Collected.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &f, nil
+}
+
+// validate rejects `resources` on the plugins whose metrics are not published per resource
+func (c Collected) validate() error {
+	others := []struct {
+		name string
+		p    *Plugin
+	}{
+		{"mem", c.Mem}, {"swap", c.Swap}, {"docker", c.Docker}, {"ecs", c.ECS},
+		{"kubernetes", c.Kubernetes}, {"procstat", c.Procstat}, {"system", c.System}, {"netstat", c.Netstat},
+	}
+	for _, o := range others {
+		if o.p != nil && len(o.p.Resources) > 0 {
+			return fmt.Errorf("%s: resources is only supported by cpu, disk, diskio and net", o.name)
+		}
+	}
+	return nil
+}
+
+// yamlToJSON converts yaml to json so that a single set of field tags applies to both formats
+func yamlToJSON(b []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	v, err := stringKeys(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// stringKeys replaces the `map[interface{}]interface{}` values decoded by yaml with `map[string]interface{}`
+func stringKeys(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", k)
+			}
+			val, err := stringKeys(val)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = val
+		}
+		return m, nil
+	case []interface{}:
+		for i, val := range t {
+			val, err := stringKeys(val)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = val
+		}
+	}
+	return v, nil
+}
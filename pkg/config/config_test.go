@@ -0,0 +1,103 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProcstatArray(t *testing.T) {
+	// the procstat plugin of the CloudWatch Agent configuration file
+	var c Collected
+	err := json.Unmarshal([]byte(`{
+		"procstat": [
+			{"exe": "nginx", "measurement": ["cpu_usage", {"name": "memory_rss", "rename": "RSS"}]},
+			{"pid_file": "/var/run/sshd.pid"}
+		]
+	}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Process{
+		{Exe: "nginx", Measurement: []Measurement{{Name: "cpu_usage"}, {Name: "memory_rss", Rename: "RSS"}}},
+		{PidFile: "/var/run/sshd.pid"},
+	}
+	if c.Procstat == nil || !reflect.DeepEqual(c.Procstat.Processes, want) {
+		t.Errorf("procstat = %+v, want processes %+v", c.Procstat, want)
+	}
+}
+
+func TestProcstatObject(t *testing.T) {
+	var c Collected
+	err := json.Unmarshal([]byte(`{
+		"procstat": {"processes": [{"systemd_unit": "docker"}], "metrics_collection_interval": 10}
+	}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Procstat == nil || c.Procstat.Interval != 10 || len(c.Procstat.Processes) != 1 || c.Procstat.Processes[0].SystemdUnit != "docker" {
+		t.Errorf("procstat = %+v", c.Procstat)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	f, err := Load(filepath.Join("..", "..", "doc", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Metrics.Collected.Procstat == nil || len(f.Metrics.Collected.Procstat.Processes) == 0 {
+		t.Errorf("procstat = %+v", f.Metrics.Collected.Procstat)
+	}
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	yaml := "metrics:\n  metrics_collected:\n    procstat:\n      - exe: nginx\n        measurement: [pid_count]\n"
+	if err := ioutil.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Process{{Exe: "nginx", Measurement: []Measurement{{Name: "pid_count"}}}}
+	if p := f.Metrics.Collected.Procstat; p == nil || !reflect.DeepEqual(p.Processes, want) {
+		t.Errorf("procstat = %+v, want processes %+v", p, want)
+	}
+}
+
+func TestLoadResources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		collected string
+		ok        bool
+	}{
+		{`{"cpu": {"resources": ["cpu0"]}, "net": {"resources": ["eth0"]}}`, true},
+		{`{"disk": {"resources": ["/"]}, "diskio": {"resources": ["*"]}}`, true},
+		{`{"mem": {"resources": ["*"]}}`, false},
+		{`{"procstat": {"processes": [{"exe": "nginx"}], "resources": ["nginx"]}}`, false},
+	}
+	path := filepath.Join(dir, "config.json")
+	for _, tt := range tests {
+		b := []byte(`{"metrics": {"metrics_collected": ` + tt.collected + `}}`)
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %t", tt.collected, err, tt.ok)
+		}
+	}
+}
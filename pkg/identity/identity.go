@@ -0,0 +1,126 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/slatunje/aws-cwa-metric/pkg/service"
+)
+
+// ModeXXX selects how the identity of the host is resolved
+const (
+	ModeAuto   = "auto"
+	ModeEC2    = "ec2"
+	ModeStatic = "static"
+	ModeHost   = "host"
+)
+
+// Identity describes the host metrics are collected on, only Host is always known
+type Identity struct {
+	Host             string
+	InstanceID       string
+	ImageID          string
+	InstanceType     string
+	Region           string
+	AvailabilityZone string
+	AccountID        string
+}
+
+// Provider resolves the identity of the host
+type Provider interface {
+	Identity() (Identity, error)
+}
+
+// New returns the Provider for mode, static holds the values used by the static mode
+func New(mode string, md service.EC2MetaData, static Identity) (Provider, error) {
+	switch mode {
+	case ModeEC2:
+		return EC2{MetaData: md}, nil
+	case ModeStatic:
+		return Static{Values: static}, nil
+	case ModeHost:
+		return Host{}, nil
+	case ModeAuto, "":
+		auto := Auto{EC2{MetaData: md}}
+		if static != (Identity{}) {
+			auto = append(auto, Static{Values: static})
+		}
+		return append(auto, Host{}), nil
+	}
+	return nil, fmt.Errorf("unknown identity mode %q", mode)
+}
+
+// EC2 resolves the identity from the instance metadata service
+type EC2 struct {
+	MetaData service.EC2MetaData
+}
+
+// Identity returns the identity found in the instance identity document
+func (e EC2) Identity() (Identity, error) {
+	doc, err := e.MetaData.IDDoc()
+	if err != nil {
+		return Identity{}, err
+	}
+	host, _ := os.Hostname()
+	return Identity{
+		Host:             host,
+		InstanceID:       doc.InstanceID,
+		ImageID:          doc.ImageID,
+		InstanceType:     doc.InstanceType,
+		Region:           doc.Region,
+		AvailabilityZone: doc.AvailabilityZone,
+		AccountID:        doc.AccountID,
+	}, nil
+}
+
+// Static resolves the identity from configured values
+type Static struct {
+	Values Identity
+}
+
+// Identity returns the configured values, the host name is looked up when left out
+func (s Static) Identity() (Identity, error) {
+	id := s.Values
+	if id.Host == "" {
+		id.Host, _ = os.Hostname()
+	}
+	if id.Host == "" && id.InstanceID == "" {
+		return Identity{}, errors.New("static identity requires a host or an instance id")
+	}
+	return id, nil
+}
+
+// Host resolves the identity from the host name
+type Host struct{}
+
+// Identity returns an identity that only holds the host name
+func (h Host) Identity() (Identity, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{Host: host}, nil
+}
+
+// Auto tries each Provider in turn and returns the first identity resolved
+type Auto []Provider
+
+// Identity returns the identity of the first Provider that succeeds
+func (a Auto) Identity() (Identity, error) {
+	var err error
+	for _, p := range a {
+		var id Identity
+		if id, err = p.Identity(); err == nil {
+			return id, nil
+		}
+		log.Printf("identity - %T failed: %s", p, err)
+	}
+	if err == nil {
+		err = errors.New("no identity provider")
+	}
+	return Identity{}, err
+}
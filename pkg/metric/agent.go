@@ -0,0 +1,79 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/slatunje/aws-cwa-metric/pkg/service"
+)
+
+// self metrics describing the health of the agent
+const (
+	AgentCollectErrors = "cwametric_collect_errors"
+	AgentPutRequests   = "cwametric_put_requests"
+	AgentPutRetries    = "cwametric_put_retries"
+	AgentPutThrottles  = "cwametric_put_throttles"
+	AgentDatumsSpooled = "cwametric_datums_spooled"
+	AgentDatumsDropped = "cwametric_datums_dropped"
+)
+
+// Agent metric entity, Stats are the counts of the publisher if any
+type Agent struct {
+	Stats *service.Stats
+
+	mu     sync.Mutex
+	errors map[string]int
+}
+
+// self is the agent instance shared by the scheduler and the registry
+var self = &Agent{errors: make(map[string]int)}
+
+// Collected records the outcome of a Gather for the collector registered as key
+func (a *Agent) Collected(key string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err != nil {
+		a.errors[key]++
+		return
+	}
+	a.errors[key] += 0
+}
+
+// Gather Agent errors per collector and publisher counts since the previous call
+func (a *Agent) Gather(ctx context.Context) (samples []Sample, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	keys := make([]string, 0, len(a.errors))
+	for k := range a.errors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		samples = append(samples, Sample{
+			Name:       AgentCollectErrors,
+			Value:      float64(a.errors[k]),
+			Unit:       UnitCount,
+			Dimensions: []Dimension{{Name: "collector", Value: k}},
+		})
+		a.errors[k] = 0
+	}
+
+	if a.Stats != nil {
+		st := a.Stats.Take()
+		var add = func(name string, value int64) {
+			samples = append(samples, Sample{Name: name, Value: float64(value), Unit: UnitCount})
+		}
+		add(AgentPutRequests, st.Requests)
+		add(AgentPutRetries, st.Retries)
+		add(AgentPutThrottles, st.Throttles)
+		add(AgentDatumsSpooled, st.Spooled)
+		add(AgentDatumsDropped, st.Dropped)
+	}
+
+	return samples, nil
+}
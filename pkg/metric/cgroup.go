@@ -0,0 +1,267 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CGroupMountInfo lists the mounts seen by this process, including the cgroup hierarchies
+const CGroupMountInfo = "/proc/self/mountinfo"
+
+// userHZ is the unit of the cpu times of cgroup v1 (i.e. cpuacct.stat)
+const userHZ = 100
+
+// CGroups locates the cgroup hierarchies of the host
+type CGroups struct {
+	Unified string            // mount point of the cgroup v2 hierarchy
+	V1      map[string]string // mount point of each cgroup v1 controller (i.e. memory, cpuacct)
+}
+
+// MountedCGroups reads the cgroup hierarchies from a mountinfo file
+// https://www.kernel.org/doc/Documentation/filesystems/proc.txt (3.5 /proc/<pid>/mountinfo)
+func MountedCGroups(mountinfo string) (cg CGroups, err error) {
+	f, err := os.Open(mountinfo)
+	if err != nil {
+		return cg, err
+	}
+	defer f.Close()
+
+	cg.V1 = make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 / /sys/fs/cgroup/memory rw,nosuid - cgroup cgroup rw,memory
+		parts := strings.SplitN(scanner.Text(), " - ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		mount, fs := strings.Fields(parts[0]), strings.Fields(parts[1])
+		if len(mount) < 5 || len(fs) < 3 {
+			continue
+		}
+		point := strings.Replace(mount[4], `\040`, " ", -1)
+		switch fs[0] {
+		case "cgroup2":
+			cg.Unified = point
+		case "cgroup":
+			for _, opt := range strings.Split(fs[2], ",") {
+				if _, ok := cg.V1[opt]; !ok && opt != "rw" && opt != "ro" {
+					cg.V1[opt] = point
+				}
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return cg, err
+	}
+	if cg.Unified == "" && len(cg.V1) == 0 {
+		return cg, fmt.Errorf("no cgroup hierarchy in %s", mountinfo)
+	}
+	return cg, nil
+}
+
+// V2 reports whether containers are accounted in the unified hierarchy, on hybrid hosts
+// the v1 memory controller takes precedence
+func (cg CGroups) V2() bool {
+	return cg.Unified != "" && cg.V1["memory"] == ""
+}
+
+// Container returns the usage of the container with id, in the layout of either the
+// cgroupfs (docker/<id>) or the systemd (system.slice/docker-<id>.scope) cgroup driver
+func (cg CGroups) Container(id string) ([]Sample, error) {
+	if cg.V2() {
+		dir, err := containerDir(cg.Unified, id)
+		if err != nil {
+			return nil, err
+		}
+		return cgroupV2(dir)
+	}
+	return cgroupV1(cg.V1, id)
+}
+
+// containerDir returns the directory of a container below the mount point of a hierarchy
+func containerDir(root, id string) (string, error) {
+	for _, dir := range []string{
+		filepath.Join(root, "docker", id),
+		filepath.Join(root, "system.slice", "docker-"+id+".scope"),
+	} {
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup for container %s in %s", id, root)
+}
+
+// cgroupV2 reads memory.current, memory.stat, cpu.stat, io.stat and pids.current,
+// only memory.current is required as the other controllers may not be enabled
+func cgroupV2(dir string) (samples []Sample, err error) {
+	var add = func(name string, value float64, unit Unit, counter bool) {
+		samples = append(samples, Sample{Name: name, Value: value, Unit: unit, Counter: counter})
+	}
+
+	usage, err := readUint(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return nil, err
+	}
+	add(DockerContainerMemory, float64(usage), UnitBytes, false)
+
+	if stat, err := readKeyed(filepath.Join(dir, "memory.stat")); err == nil {
+		add(DockerContainerMemoryAnon, float64(stat["anon"]), UnitBytes, false)
+		add(DockerContainerMemoryFile, float64(stat["file"]), UnitBytes, false)
+		add(DockerContainerMemoryWorkingSet, float64(workingSet(usage, stat["inactive_file"])), UnitBytes, false)
+	}
+	if stat, err := readKeyed(filepath.Join(dir, "cpu.stat")); err == nil {
+		add(DockerContainerCPUUser, float64(stat["user_usec"])/1e6, UnitSeconds, true)
+		add(DockerContainerCPUSystem, float64(stat["system_usec"])/1e6, UnitSeconds, true)
+	}
+	if io, err := readIOStat(filepath.Join(dir, "io.stat")); err == nil {
+		add(DockerContainerIOReadBytes, float64(io["rbytes"]), UnitBytes, true)
+		add(DockerContainerIOWriteBytes, float64(io["wbytes"]), UnitBytes, true)
+		add(DockerContainerIOReads, float64(io["rios"]), UnitCount, true)
+		add(DockerContainerIOWrites, float64(io["wios"]), UnitCount, true)
+	}
+	if pids, err := readUint(filepath.Join(dir, "pids.current")); err == nil {
+		add(DockerContainerPids, float64(pids), UnitCount, false)
+	}
+	return samples, nil
+}
+
+// cgroupV1 reads the memory, cpuacct, blkio and pids controllers mounted in mounts,
+// only the memory controller is required
+func cgroupV1(mounts map[string]string, id string) (samples []Sample, err error) {
+	var add = func(name string, value float64, unit Unit, counter bool) {
+		samples = append(samples, Sample{Name: name, Value: value, Unit: unit, Counter: counter})
+	}
+	var controller = func(name string) (string, error) {
+		root, ok := mounts[name]
+		if !ok {
+			return "", fmt.Errorf("cgroup controller %s is not mounted", name)
+		}
+		return containerDir(root, id)
+	}
+
+	dir, err := controller("memory")
+	if err != nil {
+		return nil, err
+	}
+	usage, err := readUint(filepath.Join(dir, "memory.usage_in_bytes"))
+	if err != nil {
+		return nil, err
+	}
+	add(DockerContainerMemory, float64(usage), UnitBytes, false)
+	if stat, err := readKeyed(filepath.Join(dir, "memory.stat")); err == nil {
+		add(DockerContainerMemoryAnon, float64(stat["rss"]), UnitBytes, false)
+		add(DockerContainerMemoryFile, float64(stat["cache"]), UnitBytes, false)
+		add(DockerContainerMemoryWorkingSet, float64(workingSet(usage, stat["total_inactive_file"])), UnitBytes, false)
+	}
+
+	if dir, err := controller("cpuacct"); err == nil {
+		if stat, err := readKeyed(filepath.Join(dir, "cpuacct.stat")); err == nil {
+			add(DockerContainerCPUUser, float64(stat["user"])/userHZ, UnitSeconds, true)
+			add(DockerContainerCPUSystem, float64(stat["system"])/userHZ, UnitSeconds, true)
+		}
+	}
+	if dir, err := controller("blkio"); err == nil {
+		bytes, err1 := readBlkio(filepath.Join(dir, "blkio.throttle.io_service_bytes"))
+		ios, err2 := readBlkio(filepath.Join(dir, "blkio.throttle.io_serviced"))
+		if err1 == nil && err2 == nil {
+			add(DockerContainerIOReadBytes, float64(bytes["Read"]), UnitBytes, true)
+			add(DockerContainerIOWriteBytes, float64(bytes["Write"]), UnitBytes, true)
+			add(DockerContainerIOReads, float64(ios["Read"]), UnitCount, true)
+			add(DockerContainerIOWrites, float64(ios["Write"]), UnitCount, true)
+		}
+	}
+	if dir, err := controller("pids"); err == nil {
+		if pids, err := readUint(filepath.Join(dir, "pids.current")); err == nil {
+			add(DockerContainerPids, float64(pids), UnitCount, false)
+		}
+	}
+	return samples, nil
+}
+
+// workingSet is the memory in use less the page cache that can be reclaimed first
+func workingSet(usage, inactiveFile uint64) uint64 {
+	if inactiveFile > usage {
+		return 0
+	}
+	return usage - inactiveFile
+}
+
+// readUint reads a file holding a single number, `max` reads as zero
+func readUint(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	v := strings.TrimSpace(string(b))
+	if v == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
+
+// readKeyed reads a file of `key value` lines (i.e. memory.stat, cpu.stat)
+func readKeyed(path string) (map[string]uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]uint64)
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(f[1], 10, 64); err == nil {
+			out[f[0]] = v
+		}
+	}
+	return out, nil
+}
+
+// readIOStat sums the `key=value` fields of each device of an io.stat file
+// (i.e. `259:0 rbytes=1024 wbytes=0 rios=1 wios=0 dbytes=0 dios=0`)
+func readIOStat(path string) (map[string]uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]uint64)
+	for _, line := range strings.Split(string(b), "\n") {
+		for _, field := range strings.Fields(line) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			if v, err := strconv.ParseUint(kv[1], 10, 64); err == nil {
+				out[kv[0]] += v
+			}
+		}
+	}
+	return out, nil
+}
+
+// readBlkio sums each operation over the devices of a blkio file (i.e. `8:0 Read 1024`)
+func readBlkio(path string) (map[string]uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]uint64)
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) != 3 {
+			continue
+		}
+		if v, err := strconv.ParseUint(f[2], 10, 64); err == nil {
+			out[f[1]] += v
+		}
+	}
+	return out, nil
+}
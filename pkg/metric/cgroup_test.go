@@ -0,0 +1,201 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testContainer = "3f2a9c1d5e7b"
+
+var testCGroup = filepath.Join("testdata", "cgroup")
+
+// values returns the value of each sample by name, failing on duplicates
+func values(t *testing.T, samples []Sample) map[string]float64 {
+	out := make(map[string]float64)
+	for _, s := range samples {
+		if _, ok := out[s.Name]; ok {
+			t.Errorf("duplicate sample %s", s.Name)
+		}
+		out[s.Name] = s.Value
+	}
+	return out
+}
+
+func TestMountedCGroups(t *testing.T) {
+	tests := []struct {
+		file    string
+		unified string
+		v1      map[string]string
+		v2      bool
+	}{
+		{
+			file: "mountinfo-v1",
+			v1: map[string]string{
+				"name=systemd": "/sys/fs/cgroup/systemd",
+				"memory":       "/sys/fs/cgroup/memory",
+				"cpu":          "/sys/fs/cgroup/cpu,cpuacct",
+				"cpuacct":      "/sys/fs/cgroup/cpu,cpuacct",
+				"blkio":        "/sys/fs/cgroup/blkio",
+				"pids":         "/sys/fs/cgroup/pids",
+				"net_cls":      "/sys/fs/cgroup/net cls",
+			},
+		},
+		{
+			file:    "mountinfo-v2",
+			unified: "/sys/fs/cgroup",
+			v1:      map[string]string{},
+			v2:      true,
+		},
+		{
+			// systemd hybrid mode mounts an empty unified hierarchy next to the v1 controllers
+			file:    "mountinfo-hybrid",
+			unified: "/sys/fs/cgroup/unified",
+			v1: map[string]string{
+				"name=systemd": "/sys/fs/cgroup/systemd",
+				"memory":       "/sys/fs/cgroup/memory",
+			},
+		},
+	}
+	for _, tt := range tests {
+		cg, err := MountedCGroups(filepath.Join(testCGroup, tt.file))
+		if err != nil {
+			t.Errorf("%s: %s", tt.file, err)
+			continue
+		}
+		if cg.Unified != tt.unified {
+			t.Errorf("%s: unified = %q, want %q", tt.file, cg.Unified, tt.unified)
+		}
+		for controller, point := range tt.v1 {
+			if cg.V1[controller] != point {
+				t.Errorf("%s: %s mounted at %q, want %q", tt.file, controller, cg.V1[controller], point)
+			}
+		}
+		if len(tt.v1) == 0 && len(cg.V1) != 0 {
+			t.Errorf("%s: v1 = %v, want none", tt.file, cg.V1)
+		}
+		if cg.V2() != tt.v2 {
+			t.Errorf("%s: v2 = %t, want %t", tt.file, cg.V2(), tt.v2)
+		}
+	}
+
+	if _, err := MountedCGroups(filepath.Join(testCGroup, "mountinfo-none")); err == nil {
+		t.Error("expected an error without cgroup hierarchy")
+	}
+	if _, err := MountedCGroups(filepath.Join(testCGroup, "missing")); err == nil {
+		t.Error("expected an error for a missing mountinfo")
+	}
+}
+
+func TestCGroupV2(t *testing.T) {
+	cg := CGroups{Unified: filepath.Join(testCGroup, "v2"), V1: map[string]string{}}
+	samples, err := cg.Container(testContainer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{
+		DockerContainerMemory:           104857600,
+		DockerContainerMemoryAnon:       52428800,
+		DockerContainerMemoryFile:       41943040,
+		DockerContainerMemoryWorkingSet: 104857600 - 20971520,
+		DockerContainerCPUUser:          2,
+		DockerContainerCPUSystem:        1,
+		DockerContainerIOReadBytes:      4096 + 1024,
+		DockerContainerIOWriteBytes:     8192,
+		DockerContainerIOReads:          2,
+		DockerContainerIOWrites:         2,
+		DockerContainerPids:             7,
+	}
+	if got := values(t, samples); !reflect.DeepEqual(got, want) {
+		t.Errorf("samples = %v, want %v", got, want)
+	}
+	for _, s := range samples {
+		counter := s.Name == DockerContainerCPUUser || s.Name == DockerContainerCPUSystem ||
+			s.Name == DockerContainerIOReadBytes || s.Name == DockerContainerIOWriteBytes ||
+			s.Name == DockerContainerIOReads || s.Name == DockerContainerIOWrites
+		if s.Counter != counter {
+			t.Errorf("%s: counter = %t, want %t", s.Name, s.Counter, counter)
+		}
+	}
+}
+
+func TestCGroupV2Systemd(t *testing.T) {
+	dir, err := containerDir(filepath.Join(testCGroup, "v2"), "abc")
+	if err == nil {
+		t.Errorf("found %s for an unknown container", dir)
+	}
+
+	// only memory.current is required, the other controllers may not be enabled
+	samples, err := cgroupV2(filepath.Join(testCGroup, "v2", "system.slice", "docker-"+testContainer+".scope"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{DockerContainerMemory: 1048576}
+	if got := values(t, samples); !reflect.DeepEqual(got, want) {
+		t.Errorf("samples = %v, want %v", got, want)
+	}
+}
+
+func TestContainerDir(t *testing.T) {
+	root := filepath.Join(testCGroup, "v2")
+	dir, err := containerDir(root, testContainer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the cgroupfs layout is tried first
+	if want := filepath.Join(root, "docker", testContainer); dir != want {
+		t.Errorf("dir = %s, want %s", dir, want)
+	}
+}
+
+func TestCGroupV1(t *testing.T) {
+	v1 := filepath.Join(testCGroup, "v1")
+	cg := CGroups{
+		Unified: "/sys/fs/cgroup/unified",
+		V1: map[string]string{
+			"memory":  filepath.Join(v1, "memory"),
+			"cpuacct": filepath.Join(v1, "cpuacct"),
+			"blkio":   filepath.Join(v1, "blkio"),
+			"pids":    filepath.Join(v1, "pids"),
+		},
+	}
+	samples, err := cg.Container(testContainer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{
+		DockerContainerMemory:           209715200,
+		DockerContainerMemoryAnon:       125829120,
+		DockerContainerMemoryFile:       62914560,
+		DockerContainerMemoryWorkingSet: 209715200 - 31457280,
+		DockerContainerCPUUser:          1.5,
+		DockerContainerCPUSystem:        0.5,
+		DockerContainerIOReadBytes:      4096 + 1024,
+		DockerContainerIOWriteBytes:     8192,
+		DockerContainerIOReads:          2,
+		DockerContainerIOWrites:         2,
+		DockerContainerPids:             3,
+	}
+	if got := values(t, samples); !reflect.DeepEqual(got, want) {
+		t.Errorf("samples = %v, want %v", got, want)
+	}
+
+	// only the memory controller is required
+	delete(cg.V1, "cpuacct")
+	delete(cg.V1, "blkio")
+	delete(cg.V1, "pids")
+	samples, err = cg.Container(testContainer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 4 {
+		t.Errorf("got %d samples with the memory controller only, want 4", len(samples))
+	}
+
+	delete(cg.V1, "memory")
+	if _, err := cg.Container(testContainer); err == nil {
+		t.Error("expected an error without memory controller")
+	}
+}
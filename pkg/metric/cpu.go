@@ -3,83 +3,122 @@
 package metric
 
 import (
-	"fmt"
+	"context"
 	"log"
+	"math"
+	"sync"
 
-	"github.com/aws/aws-sdk-go-v2/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	"github.com/shirou/gopsutil/cpu"
-	"github.com/slatunje/aws-cwa-metric/pkg/service"
 )
 
 // https://github.com/shirou/gopsutil/blob/master/cpu/cpu.go
 // https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/viewing_metrics_with_cloudwatch.html
 // https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/metrics-collected-by-CloudWatch-agent.html
 const (
-	CPUUsageIdle   = "cpu_usage_idle"
-	CPUUsageSystem = "cpu_usage_system"
-	CPUUsageIOWait = "cpu_usage_iowait"
-	CPUUsageUser   = "cpu_usage_user"
+	CPUUsageIdle    = "cpu_usage_idle"
+	CPUUsageSystem  = "cpu_usage_system"
+	CPUUsageIOWait  = "cpu_usage_iowait"
+	CPUUsageUser    = "cpu_usage_user"
+	CPUUsageSteal   = "cpu_usage_steal"
+	CPUUsageNice    = "cpu_usage_nice"
+	CPUUsageIRQ     = "cpu_usage_irq"
+	CPUUsageSoftIRQ = "cpu_usage_softirq"
+	CPUUsageGuest   = "cpu_usage_guest"
+	CPUUsageActive  = "cpu_usage_active"
 )
 
-// CPU
-type CPU struct{}
+// CPUTotal is the `cpu` dimension value of the aggregate series
+const CPUTotal = "cpu-total"
 
-// Collect Swap usage
-func (c CPU) Collect(doc ec2metadata.EC2InstanceIdentityDocument, cw service.CloudWatch, namespace string) {
-	metrics, err := cpu.Info()
-	if err != nil {
-		log.Fatal(err)
+// CPU metric entity, percentages are computed between two consecutive gathers
+type CPU struct {
+	PerCore bool
+	Total   bool
+
+	mu       sync.Mutex
+	previous map[string]cpu.TimesStat
+}
+
+// NewCPU returns an instance of `CPU` publishing per core and / or total usage
+func NewCPU(perCore, total bool) *CPU {
+	return &CPU{PerCore: perCore, Total: total, previous: make(map[string]cpu.TimesStat)}
+}
+
+// Gather CPU usage, the first call only records the cpu times
+func (c *CPU) Gather(ctx context.Context) (samples []Sample, err error) {
+	var times []cpu.TimesStat
+
+	if c.Total {
+		t, err := cpu.TimesWithContext(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		for i := range t {
+			t[i].CPU = CPUTotal
+		}
+		times = append(times, t...)
 	}
 
-	times, err := cpu.Times(true)
-	if err != nil {
-		log.Fatal(err)
+	if c.PerCore {
+		t, err := cpu.TimesWithContext(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, t...)
 	}
 
-	var publish = func(name string, value float64, unit cloudwatch.StandardUnit, dime []cloudwatch.Dimension) {
-		cw.Publish(NewDatum(name, value, unit, dime), namespace)
+	var add = func(name string, value float64, dime []Dimension) {
+		samples = append(samples, Sample{Name: name, Value: value, Unit: UnitPercent, Dimensions: dime})
 	}
 
-	for _, m := range metrics {
-
-		for _, t := range times {
-
-			key1 := "InstanceId"
-			key2 := "ImageId"
-			key3 := "InstanceType"
-			key4 := "cpu"
-			val4 := fmt.Sprintf("cpu%d", m.CPU)
-			dime := []cloudwatch.Dimension{
-				{
-					Name:  &key1,
-					Value: &doc.InstanceID,
-				},
-				{
-					Name:  &key2,
-					Value: &doc.ImageID,
-				},
-				{
-					Name:  &key3,
-					Value: &doc.InstanceType,
-				},
-				{
-					Name:  &key4,
-					Value: &val4,
-				},
-			}
-
-			publish(CPUUsageIdle, t.Idle, cloudwatch.StandardUnitPercent, dime)
-			publish(CPUUsageSystem, t.System, cloudwatch.StandardUnitPercent, dime)
-			publish(CPUUsageIOWait, t.Iowait, cloudwatch.StandardUnitPercent, dime)
-			publish(CPUUsageUser, t.User, cloudwatch.StandardUnitPercent, dime)
-
-			log.Printf("cpu - %s:%v%% %s:%v %s:%v %s:%v \n",
-				CPUUsageIdle, t.Idle, CPUUsageSystem, t.System, CPUUsageIOWait, t.Iowait, CPUUsageUser, t.User,
-			)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
+	for _, t := range times {
+
+		prev, ok := c.previous[t.CPU]
+		c.previous[t.CPU] = t
+		if !ok {
+			continue
 		}
 
+		elapsed := busy(t) + t.Idle + t.Iowait - busy(prev) - prev.Idle - prev.Iowait
+		if elapsed <= 0 {
+			continue
+		}
+		var percent = func(cur, prev float64) float64 {
+			return math.Min(100, math.Max(0, 100*(cur-prev)/elapsed))
+		}
+
+		dime := []Dimension{
+			{
+				Name:  "cpu",
+				Value: t.CPU,
+			},
+		}
+
+		add(CPUUsageUser, percent(t.User, prev.User), dime)
+		add(CPUUsageSystem, percent(t.System, prev.System), dime)
+		add(CPUUsageIdle, percent(t.Idle, prev.Idle), dime)
+		add(CPUUsageIOWait, percent(t.Iowait, prev.Iowait), dime)
+		add(CPUUsageSteal, percent(t.Steal, prev.Steal), dime)
+		add(CPUUsageNice, percent(t.Nice, prev.Nice), dime)
+		add(CPUUsageIRQ, percent(t.Irq, prev.Irq), dime)
+		add(CPUUsageSoftIRQ, percent(t.Softirq, prev.Softirq), dime)
+		add(CPUUsageGuest, percent(t.Guest, prev.Guest), dime)
+		add(CPUUsageActive, percent(busy(t), busy(prev)), dime)
+
+		log.Printf("cpu - %s active:%.2f%% user:%.2f%% system:%.2f%% iowait:%.2f%%\n", t.CPU,
+			percent(busy(t), busy(prev)), percent(t.User, prev.User), percent(t.System, prev.System), percent(t.Iowait, prev.Iowait),
+		)
+
 	}
 
+	return samples, nil
+}
+
+// busy returns the time spent neither idle nor waiting for io,
+// guest time is already accounted for in user time on linux
+func busy(t cpu.TimesStat) float64 {
+	return t.User + t.System + t.Nice + t.Irq + t.Softirq + t.Steal
 }
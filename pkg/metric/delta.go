@@ -0,0 +1,171 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Mode selects how the samples of a cumulative counter are published
+type Mode int
+
+// ModeXXX can be combined to publish a counter in several ways
+const (
+	ModeRaw Mode = 1 << iota
+	ModeDelta
+	ModeRate
+)
+
+// suffixes appended to the name of a counter for its derived samples
+const (
+	SuffixDelta = "_delta"
+	SuffixRate  = "_rate"
+)
+
+var modes = map[string]Mode{
+	"raw":   ModeRaw,
+	"delta": ModeDelta,
+	"rate":  ModeRate,
+}
+
+// ParseMode parses a list of modes separated by `+` or `,` such as "delta+rate"
+func ParseMode(s string) (m Mode, err error) {
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == '+' || r == ',' }) {
+		v, ok := modes[strings.ToLower(strings.TrimSpace(f))]
+		if !ok {
+			return 0, fmt.Errorf("unknown counter mode %q", f)
+		}
+		m |= v
+	}
+	if m == 0 {
+		return 0, fmt.Errorf("empty counter mode %q", s)
+	}
+	return m, nil
+}
+
+// ParseModes parses per metric modes given as `name=mode` pairs
+func ParseModes(pairs []string) (map[string]Mode, error) {
+	out := make(map[string]Mode, len(pairs))
+	for _, p := range pairs {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("counter mode %q is not of the form name=mode", p)
+		}
+		m, err := ParseMode(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		out[strings.TrimSpace(kv[0])] = m
+	}
+	return out, nil
+}
+
+// point is the previous observation of a counter
+type point struct {
+	value float64
+	at    time.Time
+}
+
+// Deltas turns cumulative counters into deltas and per second rates
+type Deltas struct {
+	Default Mode
+	Modes   map[string]Mode
+	Expiry  time.Duration
+
+	previous map[string]point
+}
+
+// NewDeltas returns an instance of `Deltas`,
+// series that have not been seen for longer than expiry start over
+func NewDeltas(def Mode, modes map[string]Mode, expiry time.Duration) *Deltas {
+	return &Deltas{Default: def, Modes: modes, Expiry: expiry, previous: make(map[string]point)}
+}
+
+// Apply replaces the counters in samples according to their mode, gauges are returned unchanged.
+// The first observation of a series and observations after a reset only yield the raw value.
+func (d *Deltas) Apply(samples []Sample, now time.Time) (out []Sample) {
+	for _, s := range samples {
+		if !s.Counter {
+			out = append(out, s)
+			continue
+		}
+
+		mode, ok := d.Modes[s.Name]
+		if !ok {
+			mode = d.Default
+		}
+		if mode&ModeRaw != 0 {
+			out = append(out, s)
+		}
+
+		key := seriesKey(s)
+		prev, seen := d.previous[key]
+		d.previous[key] = point{value: s.Value, at: now}
+		if !seen {
+			continue
+		}
+
+		elapsed := now.Sub(prev.at).Seconds()
+		delta, ok := increase(prev.value, s.Value)
+		if !ok || elapsed <= 0 {
+			continue
+		}
+
+		if mode&ModeDelta != 0 {
+			out = append(out, derive(s, SuffixDelta, delta, s.Unit))
+		}
+		if mode&ModeRate != 0 {
+			out = append(out, derive(s, SuffixRate, delta/elapsed, rateUnit(s.Unit)))
+		}
+	}
+
+	for key, p := range d.previous {
+		if now.Sub(p.at) > d.Expiry {
+			delete(d.previous, key)
+		}
+	}
+
+	return out
+}
+
+// increase returns the increase between two counter values, a counter that went backwards is
+// assumed to have been reset (i.e. interface re-created or host rebooted) and yields nothing since
+// the counters read on 64 bit kernels do not wrap at 32 bits
+func increase(prev, cur float64) (float64, bool) {
+	if cur < prev {
+		return 0, false
+	}
+	return cur - prev, true
+}
+
+// derive returns a copy of s renamed with suffix
+func derive(s Sample, suffix string, value float64, unit Unit) Sample {
+	s.Name += suffix
+	s.Value = value
+	s.Unit = unit
+	s.Counter = false
+	return s
+}
+
+// rateUnit returns the per second unit for unit
+func rateUnit(unit Unit) Unit {
+	switch unit {
+	case UnitBytes:
+		return UnitBytesSecond
+	case UnitCount:
+		return UnitCountSecond
+	}
+	return UnitNone
+}
+
+// seriesKey identifies a sample by name and dimensions
+func seriesKey(s Sample) string {
+	var b strings.Builder
+	b.WriteString(s.Name)
+	for _, d := range s.Dimensions {
+		b.WriteString("\x00" + d.Name + "=" + d.Value)
+	}
+	return b.String()
+}
@@ -0,0 +1,182 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Mode
+		ok   bool
+	}{
+		{"raw", ModeRaw, true},
+		{"delta+rate", ModeDelta | ModeRate, true},
+		{"RAW, rate", ModeRaw | ModeRate, true},
+		{"rate+rate", ModeRate, true},
+		{"", 0, false},
+		{"+", 0, false},
+		{"sum", 0, false},
+		{"raw+sum", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMode(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseMode(%q) = %v, %v, want %v ok %t", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseModes(t *testing.T) {
+	got, err := ParseModes([]string{"net_bytes_recv=raw+rate", " diskio_reads = delta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Mode{"net_bytes_recv": ModeRaw | ModeRate, "diskio_reads": ModeDelta}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("modes = %v, want %v", got, want)
+	}
+	for _, in := range []string{"net_bytes_recv", "net_bytes_recv=sum"} {
+		if _, err := ParseModes([]string{in}); err == nil {
+			t.Errorf("expected an error for %q", in)
+		}
+	}
+}
+
+func TestDeltasModes(t *testing.T) {
+	start := time.Date(2018, 11, 5, 10, 0, 0, 0, time.UTC)
+	counter := func(v float64) []Sample {
+		return []Sample{
+			{Name: DiskReadBytes, Value: v, Unit: UnitBytes, Counter: true},
+			{Name: DiskIOPsInProgress, Value: 3, Unit: UnitCount},
+		}
+	}
+	tests := []struct {
+		mode Mode
+		want map[string]float64
+	}{
+		{ModeRate, map[string]float64{DiskReadBytes + SuffixRate: 10, DiskIOPsInProgress: 3}},
+		{ModeDelta, map[string]float64{DiskReadBytes + SuffixDelta: 600, DiskIOPsInProgress: 3}},
+		{ModeRaw, map[string]float64{DiskReadBytes: 1600, DiskIOPsInProgress: 3}},
+		{ModeRaw | ModeDelta | ModeRate, map[string]float64{
+			DiskReadBytes: 1600, DiskReadBytes + SuffixDelta: 600, DiskReadBytes + SuffixRate: 10, DiskIOPsInProgress: 3,
+		}},
+	}
+	for _, tt := range tests {
+		d := NewDeltas(tt.mode, nil, time.Hour)
+
+		// the first observation only yields the raw value, if any
+		first := values(t, d.Apply(counter(1000), start))
+		if _, ok := first[DiskReadBytes]; ok != (tt.mode&ModeRaw != 0) || len(first) > 2 {
+			t.Errorf("mode %v: first = %v", tt.mode, first)
+		}
+
+		got := values(t, d.Apply(counter(1600), start.Add(time.Minute)))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mode %v: samples = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestDeltasUnits(t *testing.T) {
+	start := time.Date(2018, 11, 5, 10, 0, 0, 0, time.UTC)
+	d := NewDeltas(ModeDelta|ModeRate, map[string]Mode{DiskReadTimes: ModeRate}, time.Hour)
+	samples := func(v float64) []Sample {
+		return []Sample{
+			{Name: DiskReadBytes, Value: v, Unit: UnitBytes, Counter: true},
+			{Name: DiskIOReads, Value: v, Unit: UnitCount, Counter: true},
+			{Name: DiskReadTimes, Value: v, Unit: UnitMilliseconds, Counter: true},
+		}
+	}
+	d.Apply(samples(0), start)
+	units := make(map[string]Unit)
+	for _, s := range d.Apply(samples(60), start.Add(time.Minute)) {
+		units[s.Name] = s.Unit
+		if s.Counter {
+			t.Errorf("%s is still a counter", s.Name)
+		}
+	}
+	want := map[string]Unit{
+		DiskReadBytes + SuffixDelta: UnitBytes,
+		DiskReadBytes + SuffixRate:  UnitBytesSecond,
+		DiskIOReads + SuffixDelta:   UnitCount,
+		DiskIOReads + SuffixRate:    UnitCountSecond,
+		DiskReadTimes + SuffixRate:  UnitNone,
+	}
+	if !reflect.DeepEqual(units, want) {
+		t.Errorf("units = %v, want %v", units, want)
+	}
+}
+
+func TestDeltasReset(t *testing.T) {
+	start := time.Date(2018, 11, 5, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		prev float64
+		cur  float64
+		want map[string]float64
+	}{
+		{"increase", 100, 160, map[string]float64{"net_bytes_recv_rate": 1}},
+		{"unchanged", 100, 100, map[string]float64{"net_bytes_recv_rate": 0}},
+		// a counter that went backwards was reset (i.e. reboot), whatever it is close to
+		{"reset", 1 << 40, 50, map[string]float64{}},
+		{"32 bit wrap", 1<<32 - 10, 20, map[string]float64{}},
+	}
+	for _, tt := range tests {
+		d := NewDeltas(ModeRate, nil, time.Hour)
+		sample := func(v float64) []Sample {
+			return []Sample{{Name: "net_bytes_recv", Value: v, Unit: UnitBytes, Counter: true}}
+		}
+		d.Apply(sample(tt.prev), start)
+		if got := values(t, d.Apply(sample(tt.cur), start.Add(time.Minute))); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: samples = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// the rate resumes from the value after the reset
+	d := NewDeltas(ModeRate, nil, time.Hour)
+	for i, v := range []float64{1000, 10, 70} {
+		got := d.Apply([]Sample{{Name: "net_bytes_recv", Value: v, Counter: true}}, start.Add(time.Duration(i)*time.Minute))
+		if i == 2 && (len(got) != 1 || got[0].Value != 1) {
+			t.Errorf("after reset = %v, want a rate of 1", got)
+		}
+	}
+}
+
+func TestDeltasSeries(t *testing.T) {
+	start := time.Date(2018, 11, 5, 10, 0, 0, 0, time.UTC)
+	d := NewDeltas(ModeDelta, nil, 2*time.Minute)
+	sample := func(iface string, v float64) Sample {
+		return Sample{Name: "net_bytes_recv", Value: v, Dimensions: []Dimension{{Name: "interface", Value: iface}}, Counter: true}
+	}
+
+	// series are told apart by their dimensions
+	d.Apply([]Sample{sample("eth0", 100), sample("eth1", 1000)}, start)
+	got := d.Apply([]Sample{sample("eth0", 110), sample("eth1", 1100)}, start.Add(time.Minute))
+	if len(got) != 2 || got[0].Value != 10 || got[1].Value != 100 {
+		t.Errorf("deltas = %v", got)
+	}
+
+	// eth1 disappears and expires, so it starts over when seen again
+	d.Apply([]Sample{sample("eth0", 120)}, start.Add(2*time.Minute))
+	d.Apply([]Sample{sample("eth0", 130)}, start.Add(4*time.Minute))
+	if _, ok := d.previous[seriesKey(sample("eth1", 0))]; ok {
+		t.Error("eth1 was not removed once expired")
+	}
+	if _, ok := d.previous[seriesKey(sample("eth0", 0))]; !ok {
+		t.Error("eth0 was removed while seen")
+	}
+	got = d.Apply([]Sample{sample("eth1", 5000)}, start.Add(5*time.Minute))
+	if len(got) != 0 {
+		t.Errorf("a series seen again after expiring yields %v, want nothing", got)
+	}
+
+	// an observation at the same time as the previous one yields nothing
+	if got := d.Apply([]Sample{sample("eth1", 5100)}, start.Add(5*time.Minute)); len(got) != 0 {
+		t.Errorf("deltas = %v, want nothing without elapsed time", got)
+	}
+}
@@ -3,12 +3,11 @@
 package metric
 
 import (
+	"context"
 	"log"
 
-	"github.com/aws/aws-sdk-go-v2/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	"github.com/shirou/gopsutil/disk"
-	"github.com/slatunje/aws-cwa-metric/pkg/service"
+	"github.com/slatunje/aws-cwa-metric/pkg/config"
 )
 
 // https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/metrics-collected-by-CloudWatch-agent.html
@@ -21,145 +20,103 @@ const (
 	DiskInodesFree    = "disk_inodes_free"
 )
 
-const (
-	DiskIoIoTimes        = "diskio_io_time"
-	DiskIOPsInProgress   = "diskio_iops_in_progress"
-	DiskIOWrites         = "diskio_writes"
-	DiskIOReads          = "diskio_reads"
-	DiskWriteBytes       = "diskio_write_bytes"
-	DiskReadBytes        = "diskio_read_bytes"
-	DiskWriteTimes       = "diskio_write_time"
-	DiskReadTimes        = "diskio_read_time"
-	DiskWeightedIO       = "diskio_weighted_io"
-	DiskMergedWriteCount = "diskio_merged_write"
-	DiskMergedReadCount  = "diskio_merged_read"
-)
-
 const (
 	PartitionDeviceCGroup  = "cgroup"
 	PartitionDeviceOverlay = "overlay"
 )
 
-// Disk metric entity
-type Disk struct{}
+// defaults of the disk filters, pseudo file systems and the mounts of container runtimes are ignored
+var (
+	DiskIgnoreDevices         = []string{PartitionDeviceCGroup, PartitionDeviceOverlay, "none", "shm"}
+	DiskIgnoreMountPoints     = []string{`re:^/(proc|sys|dev|run)(/|$)`, `re:^/var/lib/(docker|kubelet|containerd|containers)/`}
+	DiskIgnoreFileSystemTypes = []string{
+		"autofs", "binfmt_misc", "bpf", "cgroup", "cgroup2", "configfs", "debugfs", "devpts", "devtmpfs",
+		"efivarfs", "fuse.lxcfs", "fusectl", "hugetlbfs", "mqueue", "nsfs", "overlay", "aufs", "proc",
+		"pstore", "ramfs", "rpc_pipefs", "securityfs", "selinuxfs", "squashfs", "sysfs", "tmpfs", "tracefs",
+	}
+)
+
+// Disk metric entity, partitions are selected by mount point, device and file system type
+type Disk struct {
+	MountPoints     Filter
+	Devices         Filter
+	FileSystemTypes Filter
+	DropDevice      bool
+	DropFSType      bool
+}
+
+// NewDisk returns an instance of `Disk` configured by the filters of p
+func NewDisk(p config.Plugin) (d Disk, err error) {
+	var orDefault = func(ignore, def []string) []string {
+		if ignore == nil {
+			return def
+		}
+		return ignore
+	}
+	if d.MountPoints, err = NewFilter(p.MountPoints, orDefault(p.IgnoreMountPoints, DiskIgnoreMountPoints)); err != nil {
+		return
+	}
+	if d.Devices, err = NewFilter(p.Devices, orDefault(p.IgnoreDevices, DiskIgnoreDevices)); err != nil {
+		return
+	}
+	if d.FileSystemTypes, err = NewFilter(p.FileSystemTypes, orDefault(p.IgnoreFileSystemTypes, DiskIgnoreFileSystemTypes)); err != nil {
+		return
+	}
+	d.DropDevice, d.DropFSType = p.DropDevice, p.DropFileSystemType
+	return
+}
 
-// Collect Disk used & free space
-func (c Disk) Collect(doc ec2metadata.EC2InstanceIdentityDocument, cw service.CloudWatch, namespace string) {
-	partitions, err := disk.Partitions(true)
-	if err != nil || len(partitions) == 0 {
-		log.Fatal(err)
+// Gather Disk used & free space, a mount point that cannot be read is reported without stopping the others
+func (c Disk) Gather(ctx context.Context) (samples []Sample, err error) {
+	partitions, err := disk.PartitionsWithContext(ctx, true)
+	if err != nil {
+		return nil, err
 	}
 
-	var publish = func(name string, value float64, unit cloudwatch.StandardUnit, dime []cloudwatch.Dimension) {
-		cw.Publish(NewDatum(name, value, unit, dime), namespace)
+	var add = func(name string, value float64, unit Unit, dime []Dimension) {
+		samples = append(samples, Sample{Name: name, Value: value, Unit: unit, Dimensions: dime})
 	}
 
-	key1 := "InstanceId"
-	key2 := "ImageId"
-	key3 := "InstanceType"
+	var errs Errors
 
 	// handle usage
 
 	for i, p := range partitions {
 
-		key4 := "device"
-		key5 := "fstype"
-		key6 := "path"
-
-		switch p.Device {
-		case PartitionDeviceCGroup, PartitionDeviceOverlay:
+		if !c.selected(p) {
 			continue
 		}
 
-		u, err := disk.Usage(p.Mountpoint)
+		u, err := disk.UsageWithContext(ctx, p.Mountpoint)
 		if err != nil {
-			log.Fatal(err)
+			errs = append(errs, err)
+			continue
 		}
-		dime := []cloudwatch.Dimension{
-			{
-				Name:  &key1,
-				Value: &doc.InstanceID,
-			},
-			{
-				Name:  &key2,
-				Value: &doc.ImageID,
-			},
-			{
-				Name:  &key3,
-				Value: &doc.InstanceType,
-			},
-			{
-				Name:  &key4,
-				Value: &p.Device,
-			},
-			{
-				Name:  &key5,
-				Value: &p.Fstype,
-			},
-			{
-				Name:  &key6,
-				Value: &p.Mountpoint,
-			},
+		var dime []Dimension
+		if !c.DropDevice {
+			dime = append(dime, Dimension{Name: "device", Value: p.Device})
 		}
+		if !c.DropFSType {
+			dime = append(dime, Dimension{Name: "fstype", Value: p.Fstype})
+		}
+		dime = append(dime, Dimension{Name: "path", Value: p.Mountpoint})
 
-		publish(DiskUsedPercent, u.UsedPercent, cloudwatch.StandardUnitPercent, dime)
-		publish(DiskUsed, float64(u.Used), cloudwatch.StandardUnitBytes, dime)
-		publish(DiskFree, float64(u.Free), cloudwatch.StandardUnitBytes, dime)
+		add(DiskUsedPercent, u.UsedPercent, UnitPercent, dime)
+		add(DiskUsed, float64(u.Used), UnitBytes, dime)
+		add(DiskFree, float64(u.Free), UnitBytes, dime)
 
-		publish(DiskInodesPercent, float64(u.InodesUsedPercent), cloudwatch.StandardUnitPercent, dime)
-		publish(DiskInodesUsed, float64(u.InodesUsed), cloudwatch.StandardUnitCount, dime)
-		publish(DiskInodesFree, float64(u.InodesFree), cloudwatch.StandardUnitCount, dime)
+		add(DiskInodesPercent, float64(u.InodesUsedPercent), UnitPercent, dime)
+		add(DiskInodesUsed, float64(u.InodesUsed), UnitCount, dime)
+		add(DiskInodesFree, float64(u.InodesFree), UnitCount, dime)
 
 		log.Printf("disk: [%d] device: %s,  mountpoint: %s, fstype: %s", i, p.Device, p.Mountpoint, p.Fstype)
 
 	}
 
-	ioc, err := disk.IOCounters()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// handle counter
-
-	for _, i := range ioc {
-
-		key4 := "IOCounter"
-
-		dime := []cloudwatch.Dimension{
-			{
-				Name:  &key1,
-				Value: &doc.InstanceID,
-			},
-			{
-				Name:  &key2,
-				Value: &doc.ImageID,
-			},
-			{
-				Name:  &key3,
-				Value: &doc.InstanceType,
-			},
-			{
-				Name:  &key4,
-				Value: &i.Name,
-			},
-		}
-
-		publish(DiskIoIoTimes, float64(i.IoTime), cloudwatch.StandardUnitMilliseconds, dime)
-		publish(DiskIOPsInProgress, float64(i.IopsInProgress), cloudwatch.StandardUnitMilliseconds, dime)
-		publish(DiskIOWrites, float64(i.WriteCount), cloudwatch.StandardUnitCount, dime)
-		publish(DiskIOReads, float64(i.ReadCount), cloudwatch.StandardUnitCount, dime)
-		publish(DiskWriteBytes, float64(i.WriteBytes), cloudwatch.StandardUnitBytes, dime)
-		publish(DiskReadBytes, float64(i.ReadBytes), cloudwatch.StandardUnitBytes, dime)
-		publish(DiskWriteTimes, float64(i.WriteTime), cloudwatch.StandardUnitMilliseconds, dime)
-		publish(DiskReadTimes, float64(i.ReadBytes), cloudwatch.StandardUnitMilliseconds, dime)
-		publish(DiskWeightedIO, float64(i.WeightedIO), cloudwatch.StandardUnitCount, dime)
-		publish(DiskMergedWriteCount, float64(i.MergedWriteCount), cloudwatch.StandardUnitCount, dime)
-		publish(DiskMergedReadCount, float64(i.MergedReadCount), cloudwatch.StandardUnitCount, dime)
-
-		log.Printf("disk - %d ms bytes(read/write): %v/%v count(read/write): %v/%v\n",
-			i.IoTime, i.ReadBytes, i.WriteBytes, i.ReadCount, i.WriteCount,
-		)
-
-	}
+	return samples, errs.Err()
+}
 
+// selected reports whether the mount point, device and file system type of p all pass the filters
+func (c Disk) selected(p disk.PartitionStat) bool {
+	return c.MountPoints.Match(p.Mountpoint) && c.Devices.Match(p.Device) && c.FileSystemTypes.Match(p.Fstype)
 }
@@ -0,0 +1,103 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/disk"
+	"github.com/slatunje/aws-cwa-metric/pkg/config"
+)
+
+func TestNewDisk(t *testing.T) {
+	partition := func(mount, device, fstype string) disk.PartitionStat {
+		return disk.PartitionStat{Mountpoint: mount, Device: device, Fstype: fstype}
+	}
+	tests := []struct {
+		name   string
+		plugin config.Plugin
+		in     disk.PartitionStat
+		want   bool
+	}{
+		{"root", config.Plugin{}, partition("/", "/dev/nvme0n1p1", "xfs"), true},
+		{"data volume", config.Plugin{}, partition("/data", "/dev/xvdf", "ext4"), true},
+		{"proc", config.Plugin{}, partition("/proc", "proc", "proc"), false},
+		{"below sys", config.Plugin{}, partition("/sys/fs/cgroup", "cgroup", "cgroup2"), false},
+		{"run", config.Plugin{}, partition("/run", "tmpfs", "tmpfs"), false},
+		{"not below run", config.Plugin{}, partition("/runner", "/dev/xvdg", "ext4"), true},
+		{"overlay of a container", config.Plugin{}, partition("/var/lib/docker/overlay2/abc/merged", "overlay", "overlay"), false},
+		{"volume of a pod", config.Plugin{}, partition("/var/lib/kubelet/pods/abc/volumes/x", "/dev/xvdh", "ext4"), false},
+		{"docker root itself", config.Plugin{}, partition("/var/lib/docker", "/dev/xvdi", "ext4"), true},
+		{"shm", config.Plugin{}, partition("/dev/shm", "shm", "tmpfs"), false},
+		{"squashfs", config.Plugin{}, partition("/snap/core/1", "/dev/loop0", "squashfs"), false},
+
+		// an ignore list replaces its defaults, an empty one clears them
+		{"ignore cleared", config.Plugin{IgnoreFileSystemTypes: []string{}}, partition("/tmp", "/dev/xvdj", "tmpfs"), true},
+		{"ignore replaced", config.Plugin{IgnoreMountPoints: []string{"/data"}}, partition("/run/x", "/dev/xvdk", "ext4"), true},
+		{"ignore replaced, match", config.Plugin{IgnoreMountPoints: []string{"/data"}}, partition("/data", "/dev/xvdf", "ext4"), false},
+		{"other defaults kept", config.Plugin{IgnoreMountPoints: []string{}}, partition("/proc", "proc", "proc"), false},
+
+		// includes select among the partitions the ignore lists keep
+		{"mount point", config.Plugin{MountPoints: []string{"/", "/data"}}, partition("/data", "/dev/xvdf", "ext4"), true},
+		{"other mount point", config.Plugin{MountPoints: []string{"/", "/data"}}, partition("/logs", "/dev/xvdl", "ext4"), false},
+		{"device", config.Plugin{Devices: []string{`re:^/dev/nvme`}}, partition("/", "/dev/nvme0n1p1", "xfs"), true},
+		{"other device", config.Plugin{Devices: []string{`re:^/dev/nvme`}}, partition("/data", "/dev/xvdf", "ext4"), false},
+		{"fstype", config.Plugin{FileSystemTypes: []string{"ext4"}}, partition("/", "/dev/nvme0n1p1", "xfs"), false},
+	}
+	for _, tt := range tests {
+		d, err := NewDisk(tt.plugin)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := d.selected(tt.in); got != tt.want {
+			t.Errorf("%s: selected %+v = %t, want %t", tt.name, tt.in, got, tt.want)
+		}
+	}
+
+	d, err := NewDisk(config.Plugin{DropDevice: true, DropFileSystemType: true})
+	if err != nil || !d.DropDevice || !d.DropFSType {
+		t.Errorf("disk = %+v, %v, want device and fstype dropped", d, err)
+	}
+	if _, err := NewDisk(config.Plugin{IgnoreDevices: []string{"re:("}}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestNewDiskIO(t *testing.T) {
+	tests := []struct {
+		name   string
+		plugin config.Plugin
+		in     map[string]bool
+	}{
+		{
+			"defaults",
+			config.Plugin{},
+			map[string]bool{
+				"sda": true, "xvda": true, "nvme0n1": true, "mmcblk0": true, "dm-0": true, "md0": true,
+				"sda1": false, "xvdf2": false, "nvme0n1p1": false, "mmcblk0p1": false,
+				"loop0": false, "ram0": false, "zram0": false, "sr0": false, "fd0": false,
+			},
+		},
+		{
+			"ignore cleared",
+			config.Plugin{IgnoreDevices: []string{}},
+			map[string]bool{"sda1": true, "loop0": true},
+		},
+		{
+			"devices",
+			config.Plugin{Devices: []string{"nvme*"}},
+			map[string]bool{"nvme1n1": true, "nvme1n1p1": false, "xvda": false},
+		},
+	}
+	for _, tt := range tests {
+		d, err := NewDiskIO(tt.plugin)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for device, want := range tt.in {
+			if got := d.Devices.Match(device); got != want {
+				t.Errorf("%s: device %s kept = %t, want %t", tt.name, device, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/disk"
+	"github.com/slatunje/aws-cwa-metric/pkg/config"
+)
+
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/metrics-collected-by-CloudWatch-agent.html
+const (
+	DiskIoIoTimes        = "diskio_io_time"
+	DiskIOPsInProgress   = "diskio_iops_in_progress"
+	DiskIOWrites         = "diskio_writes"
+	DiskIOReads          = "diskio_reads"
+	DiskWriteBytes       = "diskio_write_bytes"
+	DiskReadBytes        = "diskio_read_bytes"
+	DiskWriteTimes       = "diskio_write_time"
+	DiskReadTimes        = "diskio_read_time"
+	DiskWeightedIO       = "diskio_weighted_io"
+	DiskMergedWriteCount = "diskio_merged_write"
+	DiskMergedReadCount  = "diskio_merged_read"
+)
+
+// metrics derived from the counters of two consecutive gathers
+const (
+	DiskReadOps      = "diskio_read_ops_per_sec"
+	DiskWriteOps     = "diskio_write_ops_per_sec"
+	DiskReadRate     = "diskio_read_bytes_per_sec"
+	DiskWriteRate    = "diskio_write_bytes_per_sec"
+	DiskReadLatency  = "diskio_read_latency"
+	DiskWriteLatency = "diskio_write_latency"
+	DiskQueueDepth   = "diskio_queue_depth"
+	DiskUtil         = "diskio_util"
+)
+
+// DiskIOIgnoreDevices are left out unless ignore_devices is set: loop and ram devices and partitions
+var DiskIOIgnoreDevices = []string{
+	"loop*", "ram*", "zram*", "sr*", "fd*",
+	`re:^(sd|xvd|vd|hd)[a-z]+[0-9]+$`,
+	`re:^(nvme[0-9]+n[0-9]+|mmcblk[0-9]+)p[0-9]+$`,
+}
+
+// DiskIO metric entity, derived metrics are computed between two consecutive gathers
+type DiskIO struct {
+	Devices Filter
+
+	mu       sync.Mutex
+	previous map[string]diskIOCounters
+}
+
+// diskIOCounters are the counters of a device at the time they were read
+type diskIOCounters struct {
+	disk.IOCountersStat
+	at time.Time
+}
+
+// NewDiskIO returns an instance of `DiskIO` selecting devices with the filters of p
+func NewDiskIO(p config.Plugin) (*DiskIO, error) {
+	ignore := p.IgnoreDevices
+	if ignore == nil {
+		ignore = DiskIOIgnoreDevices
+	}
+	f, err := NewFilter(p.Devices, ignore)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskIO{Devices: f, previous: make(map[string]diskIOCounters)}, nil
+}
+
+// Gather Disk IO counters, the derived metrics of a device are left out the first time it is seen
+func (c *DiskIO) Gather(ctx context.Context) (samples []Sample, err error) {
+	ioc, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var add = func(name string, value float64, unit Unit, dime []Dimension) {
+		samples = append(samples, Sample{Name: name, Value: value, Unit: unit, Dimensions: dime})
+	}
+
+	var count = func(name string, value float64, unit Unit, dime []Dimension) {
+		samples = append(samples, Sample{Name: name, Value: value, Unit: unit, Dimensions: dime, Counter: true})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var now = time.Now()
+	var seen = make(map[string]bool)
+
+	for _, i := range ioc {
+
+		if !c.Devices.Match(i.Name) {
+			continue
+		}
+
+		dime := []Dimension{
+			{
+				Name:  "IOCounter",
+				Value: i.Name,
+			},
+		}
+
+		count(DiskIoIoTimes, float64(i.IoTime), UnitMilliseconds, dime)
+		add(DiskIOPsInProgress, float64(i.IopsInProgress), UnitCount, dime)
+		count(DiskIOWrites, float64(i.WriteCount), UnitCount, dime)
+		count(DiskIOReads, float64(i.ReadCount), UnitCount, dime)
+		count(DiskWriteBytes, float64(i.WriteBytes), UnitBytes, dime)
+		count(DiskReadBytes, float64(i.ReadBytes), UnitBytes, dime)
+		count(DiskWriteTimes, float64(i.WriteTime), UnitMilliseconds, dime)
+		count(DiskReadTimes, float64(i.ReadTime), UnitMilliseconds, dime)
+		count(DiskWeightedIO, float64(i.WeightedIO), UnitMilliseconds, dime)
+		count(DiskMergedWriteCount, float64(i.MergedWriteCount), UnitCount, dime)
+		count(DiskMergedReadCount, float64(i.MergedReadCount), UnitCount, dime)
+
+		seen[i.Name] = true
+		cur := diskIOCounters{IOCountersStat: i, at: now}
+		if prev, ok := c.previous[i.Name]; ok {
+			for _, s := range derived(prev, cur) {
+				add(s.Name, s.Value, s.Unit, dime)
+			}
+		}
+		c.previous[i.Name] = cur
+
+		log.Printf("disk - %d ms bytes(read/write): %v/%v count(read/write): %v/%v\n",
+			i.IoTime, i.ReadBytes, i.WriteBytes, i.ReadCount, i.WriteCount,
+		)
+
+	}
+
+	for name := range c.previous {
+		if !seen[name] {
+			delete(c.previous, name)
+		}
+	}
+
+	return samples, nil
+}
+
+// derived returns the rates, latencies, queue depth and utilisation of a device between two reads,
+// nothing when a counter went backwards (i.e. the device was replaced)
+func derived(prev, cur diskIOCounters) []Sample {
+	elapsed := cur.at.Sub(prev.at)
+	if elapsed <= 0 {
+		return nil
+	}
+	var delta = func(p, c uint64) (float64, bool) {
+		return float64(c - p), c >= p
+	}
+	reads, ok1 := delta(prev.ReadCount, cur.ReadCount)
+	writes, ok2 := delta(prev.WriteCount, cur.WriteCount)
+	readBytes, ok3 := delta(prev.ReadBytes, cur.ReadBytes)
+	writeBytes, ok4 := delta(prev.WriteBytes, cur.WriteBytes)
+	readTime, ok5 := delta(prev.ReadTime, cur.ReadTime)
+	writeTime, ok6 := delta(prev.WriteTime, cur.WriteTime)
+	weighted, ok7 := delta(prev.WeightedIO, cur.WeightedIO)
+	ioTime, ok8 := delta(prev.IoTime, cur.IoTime)
+	if !(ok1 && ok2 && ok3 && ok4 && ok5 && ok6 && ok7 && ok8) {
+		return nil
+	}
+
+	var perOp = func(ms, ops float64) float64 {
+		if ops == 0 {
+			return 0
+		}
+		return ms / ops
+	}
+	seconds := elapsed.Seconds()
+	millis := seconds * 1000
+
+	return []Sample{
+		{Name: DiskReadOps, Value: reads / seconds, Unit: UnitCountSecond},
+		{Name: DiskWriteOps, Value: writes / seconds, Unit: UnitCountSecond},
+		{Name: DiskReadRate, Value: readBytes / seconds, Unit: UnitBytesSecond},
+		{Name: DiskWriteRate, Value: writeBytes / seconds, Unit: UnitBytesSecond},
+		{Name: DiskReadLatency, Value: perOp(readTime, reads), Unit: UnitMilliseconds},
+		{Name: DiskWriteLatency, Value: perOp(writeTime, writes), Unit: UnitMilliseconds},
+		{Name: DiskQueueDepth, Value: weighted / millis, Unit: UnitCount},
+		{Name: DiskUtil, Value: math.Min(100, 100*ioTime/millis), Unit: UnitPercent},
+	}
+}
@@ -3,108 +3,80 @@
 package metric
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"log"
-	"os/exec"
-	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	"github.com/shirou/gopsutil/docker"
-	"github.com/slatunje/aws-cwa-metric/pkg/service"
 )
 
 // https://github.com/shirou/gopsutil/blob/master/docker/docker.go
+// https://www.kernel.org/doc/Documentation/cgroup-v2.txt
 const (
-	DockerContainerMemory    = "docker_container_mem"
-	DockerContainerCPUUser   = "docker_container_cpu_user"
-	DockerContainerCPUSystem = "docker_container_cpu_system"
+	DockerContainerMemory           = "docker_container_mem"
+	DockerContainerMemoryAnon       = "docker_container_mem_anon"
+	DockerContainerMemoryFile       = "docker_container_mem_file"
+	DockerContainerMemoryWorkingSet = "docker_container_mem_working_set"
+	DockerContainerCPUUser          = "docker_container_cpu_user"
+	DockerContainerCPUSystem        = "docker_container_cpu_system"
+	DockerContainerIOReadBytes      = "docker_container_io_read_bytes"
+	DockerContainerIOWriteBytes     = "docker_container_io_write_bytes"
+	DockerContainerIOReads          = "docker_container_io_reads"
+	DockerContainerIOWrites         = "docker_container_io_writes"
+	DockerContainerPids             = "docker_container_pids"
 )
 
-// Docker metric entity
-type Docker struct{}
-
-// On older systems, the control groups might be mounted on /cgroup
-func cGroupMountPath() (string, error) {
-	out, err := exec.Command("grep", "-m1", "cgroup", "/proc/mounts").Output()
-	if err != nil {
-		return "", errors.New("cannot figure out where control groups are mounted")
-	}
-	res := strings.Fields(string(out))
-	if strings.HasPrefix(res[1], "/cgroup") {
-		return "/cgroup", nil
-	}
-	return "/sys/fs/cgroup", nil
+// Docker metric entity, usage is read from the cgroup hierarchies listed in MountInfo
+type Docker struct {
+	MountInfo string
 }
 
-// Collect CPU & Memory usage per Docker Container
-func (c Docker) Collect(doc ec2metadata.EC2InstanceIdentityDocument, cw service.CloudWatch, namespace string) {
-	containers, err := docker.GetDockerStat()
+// Gather CPU, Memory, IO & Pids usage per Docker Container, from cgroup v1 or v2
+func (c Docker) Gather(ctx context.Context) (samples []Sample, err error) {
+	containers, err := docker.GetDockerStatWithContext(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	base, err := cGroupMountPath()
+	mountInfo := c.MountInfo
+	if mountInfo == "" {
+		mountInfo = CGroupMountInfo
+	}
+	cg, err := MountedCGroups(mountInfo)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	var publish = func(name string, value float64, unit cloudwatch.StandardUnit, dime []cloudwatch.Dimension) {
-		cw.Publish(NewDatum(name, value, unit, dime), namespace)
-	}
+	var errs Errors
 
 	for _, container := range containers {
 
-		key1 := "InstanceId"
-		key2 := "ImageId"
-		key3 := "InstanceType"
-		key4 := "ContainerId"
-		key5 := "ContainerName"
-		key6 := "DockerImage"
-
-		dime := []cloudwatch.Dimension{
-			{
-				Name:  &key1,
-				Value: &doc.InstanceID,
-			},
-			{
-				Name:  &key2,
-				Value: &doc.ImageID,
-			},
-			{
-				Name:  &key3,
-				Value: &doc.InstanceType,
-			},
+		dime := []Dimension{
 			{
-				Name:  &key4,
-				Value: &container.ContainerID,
+				Name:  "ContainerId",
+				Value: container.ContainerID,
 			},
 			{
-				Name:  &key5,
-				Value: &container.Name,
+				Name:  "ContainerName",
+				Value: container.Name,
 			},
 			{
-				Name:  &key6,
-				Value: &container.Image,
+				Name:  "DockerImage",
+				Value: container.Image,
 			},
 		}
 
-		mem, err := docker.CgroupMem(container.ContainerID, fmt.Sprintf("%s/mem/docker", base))
+		usage, err := cg.Container(container.ContainerID)
 		if err != nil {
-			log.Fatal(err)
+			errs = append(errs, err)
+			continue
 		}
-		cpu, err := docker.CgroupCPU(container.ContainerID, fmt.Sprintf("%s/cpuacct/docker", base))
-		if err != nil {
-			log.Fatal(err)
+		for _, s := range usage {
+			s.Dimensions = dime
+			samples = append(samples, s)
 		}
 
-		publish(DockerContainerMemory, float64(mem.MemUsageInBytes), cloudwatch.StandardUnitBytes, dime)
-		publish(DockerContainerCPUUser, float64(cpu.User), cloudwatch.StandardUnitSeconds, dime)
-		publish(DockerContainerCPUSystem, float64(cpu.System), cloudwatch.StandardUnitSeconds, dime)
-
-		log.Printf("docker - container:%s memory:%v user:%v system:%v\n",
-			container.Name, mem.MemMaxUsageInBytes, cpu.User, cpu.System,
-		)
+		log.Printf("docker - container:%s cgroup v2:%t samples:%d\n", container.Name, cg.V2(), len(usage))
 	}
+
+	return samples, errs.Err()
 }
@@ -0,0 +1,326 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slatunje/aws-cwa-metric/pkg/service"
+)
+
+// metrics only available from the Docker Engine API
+const (
+	DockerContainerCPUPercent    = "docker_container_cpu_percent"
+	DockerContainerMemoryLimit   = "docker_container_mem_limit"
+	DockerContainerMemoryPercent = "docker_container_mem_percent"
+	DockerContainerNetRxBytes    = "docker_container_net_rx_bytes"
+	DockerContainerNetTxBytes    = "docker_container_net_tx_bytes"
+	DockerContainerNetRxPackets  = "docker_container_net_rx_packets"
+	DockerContainerNetTxPackets  = "docker_container_net_tx_packets"
+	DockerContainerNetRxErrors   = "docker_container_net_rx_errors"
+	DockerContainerNetTxErrors   = "docker_container_net_tx_errors"
+)
+
+// container lifecycle metrics, from the state of each container and the events between two gathers
+const (
+	DockerContainersRunning      = "docker_containers_running"
+	DockerContainersPaused       = "docker_containers_paused"
+	DockerContainersStopped      = "docker_containers_stopped"
+	DockerContainerRestartCount  = "docker_container_restart_count"
+	DockerContainerOOMKilled     = "docker_container_oom_killed"
+	DockerContainerExitCode      = "docker_container_exit_code"
+	DockerContainerUptime        = "docker_container_uptime"
+	DockerContainerRestartEvents = "docker_container_restarts"
+	DockerContainerDieEvents     = "docker_container_dies"
+	DockerContainerOOMEvents     = "docker_container_ooms"
+)
+
+// DockerBackendXXX selects where container metrics are read from
+const (
+	DockerBackendCGroup = "cgroup"
+	DockerBackendAPI    = "api"
+)
+
+// dockerParallel is the number of containers whose stats are read at the same time,
+// the daemon takes about a second to answer as it samples the cpu twice
+const dockerParallel = 8
+
+// DockerAPI metric entity, usage is read from the Docker Engine API,
+// Labels are the container labels published as dimensions (i.e. com.docker.compose.service)
+type DockerAPI struct {
+	Engine *service.DockerEngine
+	Labels []string
+
+	mu    sync.Mutex
+	since time.Time
+}
+
+// Gather CPU, Memory, Network, Block IO & Pids usage per running Docker Container, the state of every
+// container, the number of containers per image and state, and the restarts, exits and oom kills
+// since the previous gather so those happening between two gathers are still counted
+func (c *DockerAPI) Gather(ctx context.Context) (samples []Sample, err error) {
+	containers, err := c.Engine.Containers(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errs Errors
+	var slots = make(chan struct{}, dockerParallel)
+	var now = time.Now()
+
+	samples = append(samples, states(containers)...)
+
+	for _, container := range containers {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(container service.DockerContainer) {
+			defer func() { <-slots; wg.Done() }()
+
+			var usage []Sample
+			inspect, err := c.Engine.Inspect(ctx, container.ID)
+			if err == nil {
+				usage = lifecycle(inspect, now)
+			}
+			var stats service.DockerStats
+			if err == nil && inspect.State.Running {
+				if stats, err = c.Engine.Stats(ctx, container.ID); err == nil {
+					usage = append(usage, dockerSamples(stats)...)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			for _, s := range usage {
+				s.Dimensions = c.dimensions(container)
+				samples = append(samples, s)
+			}
+			log.Printf("docker - container:%s state:%s restarts:%d cpu:%.2f%% memory:%v\n",
+				container.Name(), inspect.State.Status, inspect.RestartCount, cpuPercent(stats), stats.MemoryStats.Usage,
+			)
+		}(container)
+	}
+	wg.Wait()
+
+	events, err := c.events(ctx, now)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	samples = append(samples, events...)
+
+	return samples, errs.Err()
+}
+
+// states returns the number of running, paused and stopped containers of each image
+func states(containers []service.DockerContainer) (samples []Sample) {
+	type count struct{ running, paused, stopped float64 }
+	var images []string
+	var counts = make(map[string]*count)
+	for _, container := range containers {
+		n, ok := counts[container.Image]
+		if !ok {
+			n = &count{}
+			counts[container.Image] = n
+			images = append(images, container.Image)
+		}
+		switch container.State {
+		case "running", "restarting":
+			n.running++
+		case "paused":
+			n.paused++
+		default:
+			n.stopped++
+		}
+	}
+	sort.Strings(images)
+	for _, image := range images {
+		dime := []Dimension{{Name: "DockerImage", Value: image}}
+		n := counts[image]
+		samples = append(samples,
+			Sample{Name: DockerContainersRunning, Value: n.running, Unit: UnitCount, Dimensions: dime},
+			Sample{Name: DockerContainersPaused, Value: n.paused, Unit: UnitCount, Dimensions: dime},
+			Sample{Name: DockerContainersStopped, Value: n.stopped, Unit: UnitCount, Dimensions: dime},
+		)
+	}
+	return
+}
+
+// lifecycle returns the restart count, oom kill flag, last exit code and uptime of a container
+func lifecycle(inspect service.DockerInspect, now time.Time) []Sample {
+	var oom, uptime float64
+	if inspect.State.OOMKilled {
+		oom = 1
+	}
+	if inspect.State.Running && !inspect.State.StartedAt.IsZero() {
+		uptime = now.Sub(inspect.State.StartedAt).Seconds()
+	}
+	return []Sample{
+		{Name: DockerContainerRestartCount, Value: float64(inspect.RestartCount), Unit: UnitCount},
+		{Name: DockerContainerOOMKilled, Value: oom, Unit: UnitCount},
+		{Name: DockerContainerExitCode, Value: float64(inspect.State.ExitCode), Unit: UnitNone},
+		{Name: DockerContainerUptime, Value: uptime, Unit: UnitSeconds},
+	}
+}
+
+// events returns the restarts, exits and oom kills of each container since the previous call,
+// the first call only records the time
+func (c *DockerAPI) events(ctx context.Context, now time.Time) (samples []Sample, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.since.IsZero() {
+		c.since = now
+		return nil, nil
+	}
+	events, err := c.Engine.Events(ctx, c.since, now)
+	if err != nil {
+		return nil, err
+	}
+	c.since = now
+
+	var names = map[string]string{"restart": DockerContainerRestartEvents, "die": DockerContainerDieEvents, "oom": DockerContainerOOMEvents}
+	var order []string
+	var counts = make(map[string]*Sample)
+	for _, e := range events {
+		name, ok := names[e.Action]
+		if !ok {
+			continue
+		}
+		key := name + "\x00" + e.Actor.ID
+		s, ok := counts[key]
+		if !ok {
+			attrs := e.Actor.Attributes
+			s = &Sample{Name: name, Unit: UnitCount, Dimensions: c.dimensions(service.DockerContainer{
+				ID: e.Actor.ID, Names: []string{"/" + attrs["name"]}, Image: attrs["image"], Labels: attrs,
+			})}
+			counts[key] = s
+			order = append(order, key)
+		}
+		s.Value++
+	}
+	for _, key := range order {
+		samples = append(samples, *counts[key])
+	}
+	return samples, nil
+}
+
+// dimensions returns the id, name and image of a container followed by the selected labels it has,
+// leaving out those without a value
+func (c *DockerAPI) dimensions(container service.DockerContainer) []Dimension {
+	dime := []Dimension{
+		{
+			Name:  "ContainerId",
+			Value: container.ID,
+		},
+		{
+			Name:  "ContainerName",
+			Value: container.Name(),
+		},
+		{
+			Name:  "DockerImage",
+			Value: container.Image,
+		},
+	}
+	for _, label := range c.Labels {
+		dime = append(dime, Dimension{Name: label, Value: container.Labels[label]})
+	}
+	// cloud watch rejects empty values (i.e. an event without an image)
+	out := dime[:0]
+	for _, d := range dime {
+		if d.Value != "" {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// dockerSamples returns the usage found in a read of the stats of a container
+func dockerSamples(stats service.DockerStats) (samples []Sample) {
+	var add = func(name string, value float64, unit Unit) {
+		samples = append(samples, Sample{Name: name, Value: value, Unit: unit})
+	}
+	var count = func(name string, value float64, unit Unit) {
+		samples = append(samples, Sample{Name: name, Value: value, Unit: unit, Counter: true})
+	}
+
+	add(DockerContainerCPUPercent, cpuPercent(stats), UnitPercent)
+	count(DockerContainerCPUUser, float64(stats.CPUStats.CPUUsage.UsageInUsermode)/1e9, UnitSeconds)
+	count(DockerContainerCPUSystem, float64(stats.CPUStats.CPUUsage.UsageInKernelmode)/1e9, UnitSeconds)
+
+	// the percentage leaves the page cache out as `docker stats` does,
+	// `total_inactive_file` on cgroup v1 and `inactive_file` on v2
+	mem := stats.MemoryStats
+	used := mem.Usage
+	if v, ok := mem.Stats["total_inactive_file"]; ok {
+		used = workingSet(used, v)
+	} else if v, ok := mem.Stats["inactive_file"]; ok {
+		used = workingSet(used, v)
+	}
+	add(DockerContainerMemory, float64(mem.Usage), UnitBytes)
+	add(DockerContainerMemoryWorkingSet, float64(used), UnitBytes)
+	if mem.Limit > 0 {
+		add(DockerContainerMemoryLimit, float64(mem.Limit), UnitBytes)
+		add(DockerContainerMemoryPercent, 100*float64(used)/float64(mem.Limit), UnitPercent)
+	}
+
+	if len(stats.Networks) > 0 {
+		var net service.DockerNetworkStats
+		for _, n := range stats.Networks {
+			net.RxBytes += n.RxBytes
+			net.TxBytes += n.TxBytes
+			net.RxPackets += n.RxPackets
+			net.TxPackets += n.TxPackets
+			net.RxErrors += n.RxErrors
+			net.TxErrors += n.TxErrors
+		}
+		count(DockerContainerNetRxBytes, float64(net.RxBytes), UnitBytes)
+		count(DockerContainerNetTxBytes, float64(net.TxBytes), UnitBytes)
+		count(DockerContainerNetRxPackets, float64(net.RxPackets), UnitCount)
+		count(DockerContainerNetTxPackets, float64(net.TxPackets), UnitCount)
+		count(DockerContainerNetRxErrors, float64(net.RxErrors), UnitCount)
+		count(DockerContainerNetTxErrors, float64(net.TxErrors), UnitCount)
+	}
+
+	bytes, ios := blkio(stats.BlkioStats.IoServiceBytesRecursive), blkio(stats.BlkioStats.IoServicedRecursive)
+	count(DockerContainerIOReadBytes, float64(bytes["read"]), UnitBytes)
+	count(DockerContainerIOWriteBytes, float64(bytes["write"]), UnitBytes)
+	count(DockerContainerIOReads, float64(ios["read"]), UnitCount)
+	count(DockerContainerIOWrites, float64(ios["write"]), UnitCount)
+
+	add(DockerContainerPids, float64(stats.PidsStats.Current), UnitCount)
+	return
+}
+
+// cpuPercent returns the cpu used by a container since the previous read, 100% being one cpu
+func cpuPercent(stats service.DockerStats) float64 {
+	cur, prev := stats.CPUStats, stats.PreCPUStats
+	if cur.CPUUsage.TotalUsage < prev.CPUUsage.TotalUsage || cur.SystemUsage <= prev.SystemUsage {
+		return 0
+	}
+	cpus := float64(cur.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(cur.CPUUsage.PercpuUsage))
+	}
+	container := float64(cur.CPUUsage.TotalUsage - prev.CPUUsage.TotalUsage)
+	system := float64(cur.SystemUsage - prev.SystemUsage)
+	return container / system * cpus * 100
+}
+
+// blkio sums the entries per operation in lower case, which differs between cgroup v1 and v2
+func blkio(entries []service.DockerBlkioEntry) map[string]uint64 {
+	out := make(map[string]uint64)
+	for _, e := range entries {
+		out[strings.ToLower(e.Op)] += e.Value
+	}
+	return out
+}
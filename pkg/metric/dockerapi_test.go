@@ -0,0 +1,206 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/slatunje/aws-cwa-metric/pkg/service"
+)
+
+func TestCPUPercent(t *testing.T) {
+	var stats service.DockerStats
+	stats.PreCPUStats.CPUUsage.TotalUsage = 2e9
+	stats.PreCPUStats.SystemUsage = 10e9
+	stats.CPUStats.CPUUsage.TotalUsage = 3e9
+	stats.CPUStats.SystemUsage = 20e9
+	stats.CPUStats.OnlineCPUs = 4
+
+	// a tenth of the host time on a host of 4 cpus is 40% of one cpu
+	if got := cpuPercent(stats); got != 40 {
+		t.Errorf("cpu percent = %v, want 40", got)
+	}
+
+	// older daemons do not report online_cpus
+	stats.CPUStats.OnlineCPUs = 0
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{1, 2}
+	if got := cpuPercent(stats); got != 20 {
+		t.Errorf("cpu percent = %v, want 20", got)
+	}
+
+	// the first read of a container has no previous read
+	stats.PreCPUStats = service.DockerCPUStats{}
+	stats.CPUStats.SystemUsage = 0
+	if got := cpuPercent(stats); got != 0 {
+		t.Errorf("cpu percent = %v, want 0", got)
+	}
+}
+
+func TestDockerSamples(t *testing.T) {
+	var stats service.DockerStats
+	stats.CPUStats.CPUUsage.UsageInUsermode = 2e9
+	stats.CPUStats.CPUUsage.UsageInKernelmode = 1e9
+	stats.MemoryStats = service.DockerMemoryStats{
+		Usage: 100 << 20,
+		Limit: 400 << 20,
+		Stats: map[string]uint64{"inactive_file": 20 << 20},
+	}
+	stats.Networks = map[string]service.DockerNetworkStats{
+		"eth0": {RxBytes: 1000, TxBytes: 2000, RxPackets: 10, TxPackets: 20, RxErrors: 1},
+		"eth1": {RxBytes: 500, TxBytes: 500, RxPackets: 5, TxPackets: 5, TxErrors: 2},
+	}
+	stats.BlkioStats.IoServiceBytesRecursive = []service.DockerBlkioEntry{
+		{Major: 8, Minor: 0, Op: "Read", Value: 4096},
+		{Major: 8, Minor: 0, Op: "Write", Value: 8192},
+		{Major: 8, Minor: 16, Op: "read", Value: 1024},
+	}
+	stats.BlkioStats.IoServicedRecursive = []service.DockerBlkioEntry{
+		{Major: 8, Minor: 0, Op: "Read", Value: 1},
+		{Major: 8, Minor: 0, Op: "Write", Value: 2},
+	}
+	stats.PidsStats.Current = 7
+
+	samples := dockerSamples(stats)
+	want := map[string]float64{
+		DockerContainerCPUPercent:       0,
+		DockerContainerCPUUser:          2,
+		DockerContainerCPUSystem:        1,
+		DockerContainerMemory:           100 << 20,
+		DockerContainerMemoryWorkingSet: 80 << 20,
+		DockerContainerMemoryLimit:      400 << 20,
+		DockerContainerMemoryPercent:    20,
+		DockerContainerNetRxBytes:       1500,
+		DockerContainerNetTxBytes:       2500,
+		DockerContainerNetRxPackets:     15,
+		DockerContainerNetTxPackets:     25,
+		DockerContainerNetRxErrors:      1,
+		DockerContainerNetTxErrors:      2,
+		DockerContainerIOReadBytes:      4096 + 1024,
+		DockerContainerIOWriteBytes:     8192,
+		DockerContainerIOReads:          1,
+		DockerContainerIOWrites:         2,
+		DockerContainerPids:             7,
+	}
+	if got := values(t, samples); !reflect.DeepEqual(got, want) {
+		t.Errorf("samples = %v, want %v", got, want)
+	}
+
+	// without limit nor network (i.e. --network none) those metrics are left out
+	stats.MemoryStats.Limit = 0
+	stats.Networks = nil
+	got := values(t, dockerSamples(stats))
+	for _, name := range []string{DockerContainerMemoryLimit, DockerContainerMemoryPercent, DockerContainerNetRxBytes} {
+		if _, ok := got[name]; ok {
+			t.Errorf("unexpected %s", name)
+		}
+	}
+}
+
+func TestStates(t *testing.T) {
+	samples := states([]service.DockerContainer{
+		{ID: "1", Image: "nginx", State: "running"},
+		{ID: "2", Image: "nginx", State: "restarting"},
+		{ID: "3", Image: "nginx", State: "exited"},
+		{ID: "4", Image: "redis", State: "paused"},
+		{ID: "5", Image: "redis", State: "created"},
+	})
+	type key struct{ name, image string }
+	got := make(map[key]float64)
+	for _, s := range samples {
+		got[key{s.Name, s.Dimensions[0].Value}] = s.Value
+	}
+	want := map[key]float64{
+		{DockerContainersRunning, "nginx"}: 2,
+		{DockerContainersPaused, "nginx"}:  0,
+		{DockerContainersStopped, "nginx"}: 1,
+		{DockerContainersRunning, "redis"}: 0,
+		{DockerContainersPaused, "redis"}:  1,
+		{DockerContainersStopped, "redis"}: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("states = %v, want %v", got, want)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	var inspect service.DockerInspect
+	inspect.RestartCount = 2
+	inspect.State.Running = true
+	inspect.State.StartedAt = time.Date(2018, 11, 5, 10, 0, 0, 0, time.UTC)
+
+	got := values(t, lifecycle(inspect, inspect.State.StartedAt.Add(90*time.Second)))
+	want := map[string]float64{
+		DockerContainerRestartCount: 2,
+		DockerContainerOOMKilled:    0,
+		DockerContainerExitCode:     0,
+		DockerContainerUptime:       90,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("lifecycle = %v, want %v", got, want)
+	}
+
+	// a stopped container has no uptime
+	inspect.State.Running, inspect.State.OOMKilled, inspect.State.ExitCode = false, true, 137
+	got = values(t, lifecycle(inspect, time.Now()))
+	if got[DockerContainerUptime] != 0 || got[DockerContainerOOMKilled] != 1 || got[DockerContainerExitCode] != 137 {
+		t.Errorf("lifecycle = %v", got)
+	}
+}
+
+func TestDockerEvents(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		w.Write([]byte(`{"Type":"container","Action":"die","Actor":{"ID":"3f2a9c1d5e7b","Attributes":{"name":"web","image":"nginx","com.docker.compose.service":"web"}}}
+{"Type":"container","Action":"start","Actor":{"ID":"3f2a9c1d5e7b","Attributes":{"name":"web","image":"nginx"}}}
+{"Type":"container","Action":"die","Actor":{"ID":"3f2a9c1d5e7b","Attributes":{"name":"web","image":"nginx","com.docker.compose.service":"web"}}}
+{"Type":"container","Action":"oom","Actor":{"ID":"9b8c7d6e5f4a","Attributes":{"name":"worker"}}}
+`))
+	}))
+	defer srv.Close()
+
+	c := &DockerAPI{
+		Engine: &service.DockerEngine{Endpoint: srv.URL, Client: srv.Client()},
+		Labels: []string{"com.docker.compose.service"},
+	}
+	now := time.Now()
+
+	// the first call only records the time
+	samples, err := c.events(context.Background(), now)
+	if err != nil || len(samples) != 0 || len(queries) != 0 {
+		t.Fatalf("first call: %v %v %v", samples, err, queries)
+	}
+
+	samples, err = c.events(context.Background(), now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("got %d samples, want 2: %v", len(samples), samples)
+	}
+	die, oom := samples[0], samples[1]
+	if die.Name != DockerContainerDieEvents || die.Value != 2 {
+		t.Errorf("die = %+v", die)
+	}
+	wantDime := []Dimension{
+		{Name: "ContainerId", Value: "3f2a9c1d5e7b"},
+		{Name: "ContainerName", Value: "web"},
+		{Name: "DockerImage", Value: "nginx"},
+		{Name: "com.docker.compose.service", Value: "web"},
+	}
+	if !reflect.DeepEqual(die.Dimensions, wantDime) {
+		t.Errorf("die dimensions = %v, want %v", die.Dimensions, wantDime)
+	}
+	// dimensions without a value are left out
+	if oom.Name != DockerContainerOOMEvents || oom.Value != 1 || len(oom.Dimensions) != 2 {
+		t.Errorf("oom = %+v", oom)
+	}
+	if !c.since.Equal(now.Add(time.Minute)) {
+		t.Errorf("since = %s, want the time of the previous call", c.since)
+	}
+}
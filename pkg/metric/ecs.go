@@ -0,0 +1,125 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/slatunje/aws-cwa-metric/pkg/service"
+)
+
+// metrics of the task as a whole, those of its containers are named after the docker metrics (i.e. ecs_container_mem)
+const (
+	ECSTaskCPULimit      = "ecs_task_cpu_limit"
+	ECSTaskMemoryLimit   = "ecs_task_mem_limit"
+	ECSTaskContainers    = "ecs_task_containers"
+	ECSTaskMemoryPercent = "ecs_task_mem_percent"
+)
+
+// prefixes of the container and task series derived from the docker stats of each container
+const (
+	dockerContainerPrefix = "docker_container_"
+	ecsContainerPrefix    = "ecs_container_"
+	ecsTaskPrefix         = "ecs_task_"
+	ecsNetPrefix          = "net_"
+)
+
+// ECS metric entity, usage of the task and of its containers is read from the task metadata endpoint v4
+type ECS struct {
+	Metadata *service.ECSMetadata
+}
+
+// Gather CPU, Memory, Network & Storage usage per container and per task
+func (c ECS) Gather(ctx context.Context) (samples []Sample, err error) {
+	task, err := c.Metadata.Task(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := c.Metadata.TaskStats(ctx)
+	if err != nil {
+		// the stats of the own container are still available when those of the task are not
+		own, err := c.Metadata.Container(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s, err := c.Metadata.Stats(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stats = map[string]*service.DockerStats{own.DockerID: &s}
+	}
+
+	dime := ecsDimensions(task)
+
+	// task series sum those of its containers, the memory percentage is computed against the task limit.
+	// In awsvpc mode the containers share the interface of the task, network totals are those of one container.
+	var order []string
+	var totals = make(map[string]*Sample)
+	var running float64
+	var shared = task.SharedNetwork()
+	var network string
+
+	for _, container := range task.Containers {
+		s := stats[container.DockerID]
+		if s == nil {
+			continue
+		}
+		running++
+		cdime := append(append([]Dimension{}, dime...), Dimension{Name: "ContainerName", Value: container.Name})
+		if shared && network == "" && len(s.Networks) > 0 {
+			network = container.DockerID
+		}
+		for _, sample := range dockerSamples(*s) {
+			name := strings.TrimPrefix(sample.Name, dockerContainerPrefix)
+			summed := sample.Name != DockerContainerMemoryPercent && sample.Name != DockerContainerMemoryLimit
+			if strings.HasPrefix(name, ecsNetPrefix) && shared && network != container.DockerID {
+				summed = false
+			}
+			if summed {
+				t, ok := totals[name]
+				if !ok {
+					t = &Sample{Name: ecsTaskPrefix + name, Unit: sample.Unit, Counter: sample.Counter, Dimensions: dime}
+					totals[name] = t
+					order = append(order, name)
+				}
+				t.Value += sample.Value
+			}
+			sample.Name = ecsContainerPrefix + name
+			sample.Dimensions = cdime
+			samples = append(samples, sample)
+		}
+		log.Printf("ecs - task:%s container:%s cpu:%.2f%% memory:%v\n", task.TaskID(), container.Name, cpuPercent(*s), s.MemoryStats.Usage)
+	}
+
+	for _, name := range order {
+		samples = append(samples, *totals[name])
+	}
+	samples = append(samples, Sample{Name: ECSTaskContainers, Value: running, Unit: UnitCount, Dimensions: dime})
+	if task.Limits.CPU > 0 {
+		samples = append(samples, Sample{Name: ECSTaskCPULimit, Value: task.Limits.CPU, Unit: UnitCount, Dimensions: dime})
+	}
+	if limit := task.Limits.Memory * (1 << 20); limit > 0 {
+		samples = append(samples, Sample{Name: ECSTaskMemoryLimit, Value: limit, Unit: UnitBytes, Dimensions: dime})
+		if used, ok := totals[strings.TrimPrefix(DockerContainerMemoryWorkingSet, dockerContainerPrefix)]; ok {
+			samples = append(samples, Sample{Name: ECSTaskMemoryPercent, Value: 100 * used.Value / limit, Unit: UnitPercent, Dimensions: dime})
+		}
+	}
+
+	return samples, nil
+}
+
+// ecsDimensions returns the dimensions of a task, leaving out the service of a task started on its own
+func ecsDimensions(task service.ECSTask) (dime []Dimension) {
+	dime = append(dime, Dimension{Name: "ClusterName", Value: task.ClusterName()})
+	if task.ServiceName != "" {
+		dime = append(dime, Dimension{Name: "ServiceName", Value: task.ServiceName})
+	}
+	dime = append(dime,
+		Dimension{Name: "TaskDefinitionFamily", Value: task.Family},
+		Dimension{Name: "TaskId", Value: task.TaskID()},
+	)
+	return
+}
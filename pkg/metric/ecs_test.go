@@ -0,0 +1,141 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/slatunje/aws-cwa-metric/pkg/service"
+)
+
+// ecsTask is the /task response of a task with an application and a sidecar container
+const ecsTask = `{
+	"Cluster": "arn:aws:ecs:eu-west-1:111122223333:cluster/default",
+	"TaskARN": "arn:aws:ecs:eu-west-1:111122223333:task/default/158d1c8083dd49d6b527399fd6414f5c",
+	"Family": "web",
+	"Revision": "3",
+	"ServiceName": "web-service",
+	"LaunchType": "%s",
+	"Limits": {"CPU": 0.5, "Memory": 1024},
+	"Containers": [
+		{"DockerId": "app", "Name": "app", "Networks": [{"NetworkMode": "%s"}]},
+		{"DockerId": "sidecar", "Name": "cwametrics", "Networks": [{"NetworkMode": "%s"}]}
+	]
+}`
+
+// ecsTaskStats is the /task/stats response, in awsvpc mode both containers report the task interface
+const ecsTaskStats = `{
+	"app": {"memory_stats": {"usage": 268435456}, "networks": {"eth1": {"rx_bytes": 1000, "tx_bytes": 2000}}},
+	"sidecar": {"memory_stats": {"usage": 268435456}, "networks": {"eth1": {"rx_bytes": 1000, "tx_bytes": 2000}}}
+}`
+
+// ecsEndpoint is an httptest stand-in of the task metadata endpoint v4, paths missing from
+// responses answer 404
+func ecsEndpoint(responses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+// taskValues returns the value of each task sample by name
+func taskValues(samples []Sample) map[string]float64 {
+	out := make(map[string]float64)
+	for _, s := range samples {
+		if len(s.Dimensions) > 0 && s.Dimensions[len(s.Dimensions)-1].Name == "TaskId" {
+			out[s.Name] = s.Value
+		}
+	}
+	return out
+}
+
+func TestECSNetworkTotals(t *testing.T) {
+	tests := []struct {
+		launch, mode string
+		rx           float64
+	}{
+		{"FARGATE", "awsvpc", 1000},
+		{"EC2", "awsvpc", 1000},
+		{"EC2", "bridge", 2000},
+	}
+	for _, tt := range tests {
+		srv := ecsEndpoint(map[string]string{
+			"/v4/task":       fmt.Sprintf(ecsTask, tt.launch, tt.mode, tt.mode),
+			"/v4/task/stats": ecsTaskStats,
+		})
+		c := ECS{Metadata: &service.ECSMetadata{Endpoint: srv.URL + "/v4", Client: srv.Client()}}
+		samples, err := c.Gather(context.Background())
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s %s: %s", tt.launch, tt.mode, err)
+		}
+		task := taskValues(samples)
+		if got := task["ecs_task_net_rx_bytes"]; got != tt.rx {
+			t.Errorf("%s %s: task rx bytes = %v, want %v", tt.launch, tt.mode, got, tt.rx)
+		}
+		// other resources are always summed
+		if got := task["ecs_task_mem"]; got != 2*268435456 {
+			t.Errorf("%s %s: task memory = %v", tt.launch, tt.mode, got)
+		}
+		if got := task[ECSTaskMemoryPercent]; got != 50 {
+			t.Errorf("%s %s: task memory percent = %v, want 50", tt.launch, tt.mode, got)
+		}
+	}
+}
+
+func TestECSOwnStats(t *testing.T) {
+	// without /task/stats (i.e. an agent that does not serve it) only the own container is reported
+	srv := ecsEndpoint(map[string]string{
+		"/v4/task":  fmt.Sprintf(ecsTask, "FARGATE", "awsvpc", "awsvpc"),
+		"/v4":       `{"DockerId": "sidecar", "Name": "cwametrics"}`,
+		"/v4/stats": `{"memory_stats": {"usage": 134217728}, "networks": {"eth1": {"rx_bytes": 1000}}}`,
+	})
+	defer srv.Close()
+
+	c := ECS{Metadata: &service.ECSMetadata{Endpoint: srv.URL + "/v4", Client: srv.Client()}}
+	samples, err := c.Gather(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := taskValues(samples)
+	if task[ECSTaskContainers] != 1 || task["ecs_task_mem"] != 134217728 || task["ecs_task_net_rx_bytes"] != 1000 {
+		t.Errorf("task = %v", task)
+	}
+	for _, s := range samples {
+		if s.Name == "ecs_container_mem" && s.Dimensions[len(s.Dimensions)-1].Value != "cwametrics" {
+			t.Errorf("unexpected container %v", s.Dimensions)
+		}
+	}
+
+	// without stats at all the gather fails
+	c.Metadata.Endpoint = srv.URL + "/v3"
+	if _, err := c.Gather(context.Background()); err == nil {
+		t.Error("expected an error without task metadata")
+	}
+}
+
+func TestECSDimensions(t *testing.T) {
+	task := service.ECSTask{
+		Cluster: "arn:aws:ecs:eu-west-1:111122223333:cluster/default",
+		TaskARN: "arn:aws:ecs:eu-west-1:111122223333:task/default/158d1c8083dd49d6b527399fd6414f5c",
+		Family:  "batch",
+	}
+	want := []Dimension{
+		{Name: "ClusterName", Value: "default"},
+		{Name: "TaskDefinitionFamily", Value: "batch"},
+		{Name: "TaskId", Value: "158d1c8083dd49d6b527399fd6414f5c"},
+	}
+	// a task started on its own has no service
+	if got := ecsDimensions(task); !reflect.DeepEqual(got, want) {
+		t.Errorf("dimensions = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// regexPrefix marks a pattern as a regular expression rather than a glob
+const regexPrefix = "re:"
+
+// Filter selects values with glob patterns (i.e. `/mnt/*`) or regular expressions (i.e. `re:^/dev/sd`),
+// a value is kept when it matches one of the includes, if any, and none of the excludes
+type Filter struct {
+	include []pattern
+	exclude []pattern
+}
+
+// pattern matches a single value
+type pattern func(string) bool
+
+// NewFilter returns an instance of `Filter`, it fails on a pattern that does not compile
+func NewFilter(include, exclude []string) (f Filter, err error) {
+	if f.include, err = patterns(include); err != nil {
+		return
+	}
+	f.exclude, err = patterns(exclude)
+	return
+}
+
+// Match reports whether v is kept
+func (f Filter) Match(v string) bool {
+	if len(f.include) > 0 && !matchAny(f.include, v) {
+		return false
+	}
+	return !matchAny(f.exclude, v)
+}
+
+// patterns compiles each of the given patterns
+func patterns(in []string) (out []pattern, err error) {
+	for _, p := range in {
+		if strings.HasPrefix(p, regexPrefix) {
+			re, err := regexp.Compile(strings.TrimPrefix(p, regexPrefix))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, re.MatchString)
+			continue
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, err
+		}
+		glob := p
+		out = append(out, func(v string) bool {
+			ok, _ := filepath.Match(glob, v)
+			return ok
+		})
+	}
+	return
+}
+
+// matchAny reports whether one of the patterns matches v
+func matchAny(ps []pattern, v string) bool {
+	for _, p := range ps {
+		if p(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import "testing"
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		in      map[string]bool
+	}{
+		{"empty keeps everything", nil, nil, map[string]bool{"/": true, "": true}},
+		{"glob include", []string{"/mnt/*"}, nil, map[string]bool{"/mnt/data": true, "/mnt": false, "/mnt/a/b": false}},
+		{"glob exclude", nil, []string{"loop*"}, map[string]bool{"loop0": false, "sda": true}},
+		{"regex", []string{`re:^/dev/sd`}, nil, map[string]bool{"/dev/sda1": true, "/dev/xvda": false}},
+		{"exclude wins", []string{"sd*"}, []string{`re:[0-9]$`}, map[string]bool{"sda": true, "sda1": false, "nvme0n1": false}},
+		{"any include", []string{"eth0", "ens*"}, nil, map[string]bool{"eth0": true, "ens5": true, "eth1": false}},
+	}
+	for _, tt := range tests {
+		f, err := NewFilter(tt.include, tt.exclude)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for v, want := range tt.in {
+			if got := f.Match(v); got != want {
+				t.Errorf("%s: match %q = %t, want %t", tt.name, v, got, want)
+			}
+		}
+	}
+}
+
+func TestNewFilterInvalid(t *testing.T) {
+	for _, p := range []string{"re:(", "[a-"} {
+		if _, err := NewFilter([]string{p}, nil); err == nil {
+			t.Errorf("include %q: expected an error", p)
+		}
+		if _, err := NewFilter(nil, []string{p}); err == nil {
+			t.Errorf("exclude %q: expected an error", p)
+		}
+	}
+}
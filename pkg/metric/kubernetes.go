@@ -0,0 +1,160 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/slatunje/aws-cwa-metric/pkg/service"
+)
+
+// https://github.com/kubernetes/kubelet/blob/master/pkg/apis/stats/v1alpha1/types.go
+const (
+	K8sPodCPUUsage               = "k8s_pod_cpu_usage"
+	K8sPodMemoryUsage            = "k8s_pod_mem_usage"
+	K8sPodMemoryWorkingSet       = "k8s_pod_mem_working_set"
+	K8sPodNetRxBytes             = "k8s_pod_net_rx_bytes"
+	K8sPodNetTxBytes             = "k8s_pod_net_tx_bytes"
+	K8sPodNetRxErrors            = "k8s_pod_net_rx_errors"
+	K8sPodNetTxErrors            = "k8s_pod_net_tx_errors"
+	K8sPodEphemeralStorageUsed   = "k8s_pod_ephemeral_storage_used"
+	K8sPodVolumeUsed             = "k8s_pod_volume_used"
+	K8sPodVolumeUsedPercent      = "k8s_pod_volume_used_percent"
+	K8sContainerCPUUsage         = "k8s_container_cpu_usage"
+	K8sContainerMemoryUsage      = "k8s_container_mem_usage"
+	K8sContainerMemoryWorkingSet = "k8s_container_mem_working_set"
+	K8sContainerRootfsUsed       = "k8s_container_rootfs_used"
+	K8sContainerLogsUsed         = "k8s_container_logs_used"
+)
+
+// K8sServiceLabels are the pod labels the service of a pod is inferred from, the first one set wins
+var K8sServiceLabels = []string{"app.kubernetes.io/name", "app", "k8s-app", "name"}
+
+// K8sClusterTag is the instance tag eks sets to the name of the cluster of a node, the name is otherwise
+// read from the key of the `kubernetes.io/cluster/<name>` tag of the cloud provider convention
+const (
+	K8sClusterTag       = "eks:cluster-name"
+	k8sClusterTagPrefix = "kubernetes.io/cluster/"
+)
+
+// Kubernetes metric entity, usage of the pods of the node is read from the kubelet summary api,
+// the cpu is in use in cores
+type Kubernetes struct {
+	Kubelet     *service.Kubelet
+	ClusterName string
+}
+
+// Gather CPU, Memory, Network, Ephemeral Storage & Volume usage per pod and CPU, Memory & Storage per container
+func (c Kubernetes) Gather(ctx context.Context) (samples []Sample, err error) {
+	summary, err := c.Kubelet.Summary(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// pods are only needed for their labels, metrics are still published without a service
+	var services = make(map[string]string)
+	var errs Errors
+	if pods, err := c.Kubelet.Pods(ctx); err != nil {
+		errs = append(errs, err)
+	} else {
+		for _, p := range pods.Items {
+			services[p.Metadata.UID] = podService(p.Metadata.Labels)
+		}
+	}
+
+	for _, pod := range summary.Pods {
+
+		dime := []Dimension{
+			{Name: "ClusterName", Value: c.ClusterName},
+			{Name: "Namespace", Value: pod.PodRef.Namespace},
+			{Name: "PodName", Value: pod.PodRef.Name},
+		}
+		if s := services[pod.PodRef.UID]; s != "" {
+			dime = append(dime, Dimension{Name: "Service", Value: s})
+		}
+
+		var add = func(name string, value *uint64, scale float64, unit Unit, dime []Dimension) {
+			if value != nil {
+				samples = append(samples, Sample{Name: name, Value: float64(*value) * scale, Unit: unit, Dimensions: dime})
+			}
+		}
+		var count = func(name string, value *uint64, unit Unit) {
+			if value != nil {
+				samples = append(samples, Sample{Name: name, Value: float64(*value), Unit: unit, Dimensions: dime, Counter: true})
+			}
+		}
+
+		if pod.CPU != nil {
+			add(K8sPodCPUUsage, pod.CPU.UsageNanoCores, 1e-9, UnitNone, dime)
+		}
+		if pod.Memory != nil {
+			add(K8sPodMemoryUsage, pod.Memory.UsageBytes, 1, UnitBytes, dime)
+			add(K8sPodMemoryWorkingSet, pod.Memory.WorkingSetBytes, 1, UnitBytes, dime)
+		}
+		if pod.Network != nil {
+			count(K8sPodNetRxBytes, pod.Network.RxBytes, UnitBytes)
+			count(K8sPodNetTxBytes, pod.Network.TxBytes, UnitBytes)
+			count(K8sPodNetRxErrors, pod.Network.RxErrors, UnitCount)
+			count(K8sPodNetTxErrors, pod.Network.TxErrors, UnitCount)
+		}
+		if pod.EphemeralStorage != nil {
+			add(K8sPodEphemeralStorageUsed, pod.EphemeralStorage.UsedBytes, 1, UnitBytes, dime)
+		}
+		for _, v := range pod.Volumes {
+			vdime := append(append([]Dimension{}, dime...), Dimension{Name: "Volume", Value: v.Name})
+			add(K8sPodVolumeUsed, v.UsedBytes, 1, UnitBytes, vdime)
+			if v.UsedBytes != nil && v.CapacityBytes != nil && *v.CapacityBytes > 0 {
+				samples = append(samples, Sample{
+					Name: K8sPodVolumeUsedPercent, Value: 100 * float64(*v.UsedBytes) / float64(*v.CapacityBytes),
+					Unit: UnitPercent, Dimensions: vdime,
+				})
+			}
+		}
+
+		for _, container := range pod.Containers {
+			cdime := append(append([]Dimension{}, dime...), Dimension{Name: "ContainerName", Value: container.Name})
+			if container.CPU != nil {
+				add(K8sContainerCPUUsage, container.CPU.UsageNanoCores, 1e-9, UnitNone, cdime)
+			}
+			if container.Memory != nil {
+				add(K8sContainerMemoryUsage, container.Memory.UsageBytes, 1, UnitBytes, cdime)
+				add(K8sContainerMemoryWorkingSet, container.Memory.WorkingSetBytes, 1, UnitBytes, cdime)
+			}
+			if container.Rootfs != nil {
+				add(K8sContainerRootfsUsed, container.Rootfs.UsedBytes, 1, UnitBytes, cdime)
+			}
+			if container.Logs != nil {
+				add(K8sContainerLogsUsed, container.Logs.UsedBytes, 1, UnitBytes, cdime)
+			}
+		}
+
+		log.Printf("kubernetes - pod:%s/%s containers:%d\n", pod.PodRef.Namespace, pod.PodRef.Name, len(pod.Containers))
+	}
+
+	return samples, errs.Err()
+}
+
+// podService returns the service a pod belongs to from its labels, empty when none of K8sServiceLabels is set
+func podService(labels map[string]string) string {
+	for _, l := range K8sServiceLabels {
+		if v := labels[l]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// tagClusterName returns the name of the cluster found in the tags of a node, or an empty string
+func tagClusterName(tags map[string]string) string {
+	if name := tags[K8sClusterTag]; name != "" {
+		return name
+	}
+	for _, key := range sortedKeys(tags) {
+		if strings.HasPrefix(key, k8sClusterTagPrefix) {
+			return strings.TrimPrefix(key, k8sClusterTagPrefix)
+		}
+	}
+	return ""
+}
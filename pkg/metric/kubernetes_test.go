@@ -0,0 +1,22 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import "testing"
+
+func TestTagClusterName(t *testing.T) {
+	tests := []struct {
+		tags map[string]string
+		want string
+	}{
+		{map[string]string{"eks:cluster-name": "prod", "kubernetes.io/cluster/other": "owned"}, "prod"},
+		{map[string]string{"Name": "node", "kubernetes.io/cluster/staging": "owned"}, "staging"},
+		{map[string]string{"Name": "node"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := tagClusterName(tt.tags); got != tt.want {
+			t.Errorf("cluster of %v = %q, want %q", tt.tags, got, tt.want)
+		}
+	}
+}
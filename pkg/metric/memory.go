@@ -3,12 +3,10 @@
 package metric
 
 import (
+	"context"
 	"log"
 
-	"github.com/aws/aws-sdk-go-v2/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	"github.com/shirou/gopsutil/mem"
-	"github.com/slatunje/aws-cwa-metric/pkg/service"
 )
 
 // https://github.com/shirou/gopsutil/blob/master/mem/mem.go#L15
@@ -25,41 +23,25 @@ const (
 // Memory metric entity
 type Memory struct{}
 
-// Collect Memory utilization
-func (c Memory) Collect(doc ec2metadata.EC2InstanceIdentityDocument, cw service.CloudWatch, namespace string) {
-	m, err := mem.VirtualMemory()
+// Gather Memory utilization
+func (c Memory) Gather(ctx context.Context) (samples []Sample, err error) {
+	m, err := mem.VirtualMemoryWithContext(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	key1 := "InstanceId"
-	key2 := "ImageId"
-	key3 := "InstanceType"
-	dime := []cloudwatch.Dimension{
-		{
-			Name:  &key1,
-			Value: &doc.InstanceID,
-		},
-		{
-			Name:  &key2,
-			Value: &doc.ImageID,
-		},
-		{
-			Name:  &key3,
-			Value: &doc.InstanceType,
-		},
+	var add = func(name string, value float64, unit Unit) {
+		samples = append(samples, Sample{Name: name, Value: value, Unit: unit})
 	}
 
-	var publish = func(name string, value float64, unit cloudwatch.StandardUnit, dime []cloudwatch.Dimension) {
-		cw.Publish(NewDatum(name, value, unit, dime), namespace)
-	}
-
-	publish(MemoryTotal, float64(m.Total), cloudwatch.StandardUnitBytes, dime)
-	publish(MemoryAvailable, float64(m.Available), cloudwatch.StandardUnitBytes, dime)
-	publish(MemoryUsed, float64(m.Used), cloudwatch.StandardUnitBytes, dime)
-	publish(MemoryUsedPercent, m.UsedPercent, cloudwatch.StandardUnitPercent, dime)
-	publish(MemoryFree, float64(m.Free), cloudwatch.StandardUnitBytes, dime)
-	publish(MemoryCached, float64(m.Cached), cloudwatch.StandardUnitBytes, dime)
+	add(MemoryTotal, float64(m.Total), UnitBytes)
+	add(MemoryAvailable, float64(m.Available), UnitBytes)
+	add(MemoryUsed, float64(m.Used), UnitBytes)
+	add(MemoryUsedPercent, m.UsedPercent, UnitPercent)
+	add(MemoryFree, float64(m.Free), UnitBytes)
+	add(MemoryCached, float64(m.Cached), UnitBytes)
 
 	log.Printf("memory - utilization:%v%% used:%v available:%v\n", m.UsedPercent, m.Used, m.Available)
+
+	return samples, nil
 }
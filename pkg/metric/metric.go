@@ -4,17 +4,21 @@ package metric
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go-v2/aws/external"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/slatunje/aws-cwa-metric/pkg/config"
+	"github.com/slatunje/aws-cwa-metric/pkg/identity"
 	"github.com/slatunje/aws-cwa-metric/pkg/service"
 	"github.com/slatunje/aws-cwa-metric/pkg/utils"
 	"github.com/spf13/viper"
@@ -25,74 +29,255 @@ const (
 )
 
 const (
-	KeyCPU     = "cpu"
-	KeyDisk    = "disk"
-	KeyDocker  = "docker"
-	KeyMemory  = "memory"
-	KeyNetwork = "network"
-	KeySwap    = "swap"
+	KeyAgent    = "agent"
+	KeyCPU      = "cpu"
+	KeyDisk     = "disk"
+	KeyDiskIO   = "diskio"
+	KeyDocker   = "docker"
+	KeyECS      = "ecs"
+	KeyK8s      = "kubernetes"
+	KeyMemory   = "memory"
+	KeyNetstat  = "netstat"
+	KeyNetwork  = "network"
+	KeyProcstat = "procstat"
+	KeySwap     = "swap"
+	KeySystem   = "system"
 )
 
-var registered = map[string]Gatherer{
-	KeyCPU:     CPU{},
-	KeyDisk:    Disk{},
-	KeyDocker:  Docker{},
-	KeyMemory:  Memory{},
-	KeyNetwork: Network{},
-	KeySwap:    Swap{},
+// registered creates the Gatherer for each key from its plugin settings
+var registered = map[string]func(config.Plugin) Gatherer{
+	KeyAgent: func(config.Plugin) Gatherer { return self },
+	KeyCPU: func(p config.Plugin) Gatherer {
+		total := viper.GetBool(utils.CWACPUTotalKey)
+		if p.TotalCPU != nil {
+			total = *p.TotalCPU
+		}
+		return NewCPU(viper.GetBool(utils.CWACPUPerCoreKey) || all(p.Resources), total)
+	},
+	KeyDisk: func(p config.Plugin) Gatherer {
+		d, err := NewDisk(p)
+		if err != nil {
+			log.Fatalf("disk filters: %s", err)
+		}
+		return d
+	},
+	KeyDiskIO: func(p config.Plugin) Gatherer {
+		d, err := NewDiskIO(p)
+		if err != nil {
+			log.Fatalf("diskio filters: %s", err)
+		}
+		return d
+	},
+	KeyDocker: func(p config.Plugin) Gatherer {
+		backend, labels := viper.GetString(utils.CWADockerBackendKey), viper.GetStringSlice(utils.CWADockerLabelsKey)
+		if p.Backend != "" {
+			backend = p.Backend
+		}
+		if p.ContainerLabels != nil {
+			labels = p.ContainerLabels
+		}
+		switch backend {
+		case DockerBackendCGroup, "":
+			return Docker{}
+		case DockerBackendAPI:
+			engine, err := service.NewDockerEngine(viper.GetString(utils.CWADockerSocketKey))
+			if err != nil {
+				log.Fatal(err)
+			}
+			return &DockerAPI{Engine: engine, Labels: labels}
+		}
+		log.Fatalf("unknown docker backend %q", backend)
+		return nil
+	},
+	KeyECS: func(config.Plugin) Gatherer {
+		md, err := service.NewECSMetadata()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return ECS{Metadata: md}
+	},
+	KeyK8s: func(config.Plugin) Gatherer {
+		kubelet, err := service.NewKubelet(viper.GetString(utils.CWAKubeletKey), viper.GetBool(utils.CWAKubeletInsecureKey))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return Kubernetes{Kubelet: kubelet, ClusterName: viper.GetString(utils.CWAClusterNameKey)}
+	},
+	KeyMemory: func(config.Plugin) Gatherer { return Memory{} },
+	KeyNetwork: func(p config.Plugin) Gatherer {
+		perInterface, total := viper.GetBool(utils.CWANetPerInterfaceKey), viper.GetBool(utils.CWANetTotalKey)
+		if p.PerInterface != nil {
+			perInterface = *p.PerInterface
+		}
+		if p.TotalNet != nil {
+			total = *p.TotalNet
+		}
+		n, err := NewNetwork(p, perInterface, total)
+		if err != nil {
+			log.Fatalf("network filters: %s", err)
+		}
+		return n
+	},
+	KeyProcstat: func(p config.Plugin) Gatherer {
+		processes := p.Processes
+		for _, exe := range viper.GetStringSlice(utils.CWAProcstatExeKey) {
+			processes = append(processes, config.Process{Exe: exe})
+		}
+		ps, err := NewProcstat(processes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return ps
+	},
+	KeyNetstat: func(p config.Plugin) Gatherer {
+		ports := p.Ports
+		if ports == nil {
+			var err error
+			if ports, err = ParsePorts(viper.Get(utils.CWANetstatPortsKey)); err != nil {
+				log.Printf("netstat - sockets are not counted per port: %s", err)
+			}
+		}
+		return Netstat{Ports: ports}
+	},
+	KeySwap:   func(config.Plugin) Gatherer { return Swap{} },
+	KeySystem: func(config.Plugin) Gatherer { return System{} },
 }
 
 // Gatherer entity
 type Gatherer interface {
-	Collect(ec2metadata.EC2InstanceIdentityDocument, service.CloudWatch, string)
+	Gather(context.Context) ([]Sample, error)
+}
+
+// Errors collects the failures of a Gather that still returned partial samples
+type Errors []error
+
+// Error joins all error messages
+func (e Errors) Error() string {
+	msg := make([]string, len(e))
+	for i, err := range e {
+		msg[i] = err.Error()
+	}
+	return strings.Join(msg, "; ")
 }
 
-// NewDatum returns a slice of `[]cloudwatch.MetricDatum` data object
-func NewDatum(
-	name string,
-	value float64,
-	unit cloudwatch.StandardUnit,
-	dimensions []cloudwatch.Dimension,
-) []cloudwatch.MetricDatum {
-	return []cloudwatch.MetricDatum{
-		{
-			MetricName: &name,
-			Dimensions: dimensions,
-			Unit:       unit,
-			Value:      &value,
-		},
+// Err returns nil when no error has been collected
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
 	}
+	return e
 }
 
-// Execute starts the service
-func Execute() {
+// NewDatum returns a `service.Datum` for a sample, prefixed with the given dimensions,
+// the value of a distribution is sent as a single value with a count of 1
+func NewDatum(s Sample, dimensions []Dimension) service.Datum {
+	var dime []cloudwatch.Dimension
+	for _, d := range append(append([]Dimension{}, dimensions...), s.Dimensions...) {
+		dime = append(dime, cloudwatch.Dimension{Name: aws.String(d.Name), Value: aws.String(d.Value)})
+	}
+	d := service.Datum{MetricDatum: cloudwatch.MetricDatum{
+		MetricName: aws.String(s.Name),
+		Dimensions: dime,
+		Unit:       cloudwatch.StandardUnit(s.Unit),
+	}}
+	if s.Distribution {
+		d.Values, d.Counts = []float64{s.Value}, []float64{1}
+	} else {
+		d.Value = aws.Float64(s.Value)
+	}
+	if !s.Timestamp.IsZero() {
+		d.Timestamp = aws.Time(s.Timestamp)
+	}
+	if s.HighResolution {
+		d.StorageResolution = aws.Int64(1)
+	}
+	return d
+}
 
-	var cf = config()
-	var cm = chosen()
-	var ns = viper.GetString(utils.CWANamespaceKey)
+// Execute starts the service, settings holds the content of the configuration file if any
+func Execute(settings *config.File) {
 
-	var cw = service.NewCloudWatch(cf)
+	var cf = awsConfig()
 	var md = service.NewEC2MetaData(cf)
+	md.TokenTTL = viper.GetDuration(utils.CWAIMDSTokenTTLKey)
+	md.AllowV1 = viper.GetBool(utils.CWAIMDSv1Key)
+
+	var static = settings.Agent.Identity
+	provider, err := identity.New(viper.GetString(utils.CWAIdentityKey), md, identity.Identity{
+		Host:         static.Host,
+		InstanceID:   static.InstanceID,
+		ImageID:      static.ImageID,
+		InstanceType: static.InstanceType,
+		Region:       static.Region,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	var id, err = md.IDDoc()
+	id, err := provider.Identity()
 	if err != nil {
 		log.Fatal(err)
 	}
+	log.Printf("identity - host:%s instance:%s", id.Host, id.InstanceID)
+
+	def, err := ParseMode(viper.GetString(utils.CWACountersKey))
+	if err != nil {
+		log.Fatal(err)
+	}
+	per, err := ParseModes(viper.GetStringSlice(utils.CWACounterKey))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var tags = ec2Tags(cf, md, id, settings.Metrics)
+	if viper.GetBool(KeyPrefix+KeyK8s) || settings.Metrics.Collected.Kubernetes != nil {
+		if viper.GetString(utils.CWAClusterNameKey) == "" {
+			name, err := clusterName(cf, md, id, tags)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("kubernetes - cluster %s read from the instance tags", name)
+			viper.Set(utils.CWAClusterNameKey, name)
+		}
+	}
+	var dimensions = func() []Dimension { return hostDimensions(id) }
+	if len(settings.Metrics.AppendDimensions) > 0 {
+		dimensions = appender{dims: settings.Metrics.AppendDimensions, id: id, tags: tags}.resolve
+	}
+
+	var s = scheduler{
+		collectors: chosen(settings.Metrics.Collected, id, tags),
+		publisher:  service.NewPublisher(service.NewCloudWatch(cf), spool(), retry()),
+		namespace:  viper.GetString(utils.CWANamespaceKey),
+		dimensions: dimensions,
+		rollups:    settings.Metrics.AggregationDimensions,
+		align:      viper.GetBool(utils.CWAAlignKey),
+		flush:      viper.GetDuration(utils.CWAFlushIntervalKey),
+	}
+	for i, c := range s.collectors {
+		s.collectors[i].deltas = NewDeltas(def, per, 3*c.interval)
+	}
+	self.Stats = s.publisher.Stats
+	if s.flush > s.tick() {
+		s.publisher.Aggregator = service.NewAggregator(s.flush)
+		log.Printf("aggregating samples collected every %s into statistic sets flushed every %s", s.tick(), s.flush)
+	}
 
 	// handle one time execution?
 
 	if viper.GetBool(utils.CWAOnceKey) {
-		collect(cm, id, cw, ns)
+		s.once(context.Background())
 		return
 	}
 
-	// handle continuous execution? then trap Ctrl+C and call cancel on the context
+	// handle continuous execution? then trap Ctrl+C and SIGTERM (systemd, docker stop, kubernetes)
+	// and call cancel on the context so the last samples are flushed
 
 	ctx := context.Background()
-	ctx, cancel := OnSignal(ctx, os.Interrupt, os.Kill)
+	ctx, cancel := OnSignal(ctx, os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	forever(ctx, id, cm, cw, ns)
+	s.forever(ctx)
 }
 
 // OnSignal will listen to signals and gracefully shutdown
@@ -112,8 +297,8 @@ func OnSignal(ctx context.Context, s ...os.Signal) (context.Context, context.Can
 	return ctx, cancel
 }
 
-// config returns an aws.Config object
-func config() (cfg aws.Config) {
+// awsConfig returns an aws.Config object
+func awsConfig() (cfg aws.Config) {
 	cfg, err := external.LoadDefaultAWSConfig()
 	if err != nil {
 		panic("unable to load SDK config")
@@ -122,47 +307,123 @@ func config() (cfg aws.Config) {
 	return
 }
 
-// chosen returns a slice of chosen metrics
-func chosen() (cm []Gatherer) {
+// retry returns the retry policy applied to every request sent to cloud watch
+func retry() service.Retry {
+	return service.NewRetry(
+		viper.GetDuration(utils.CWARetryMaxElapsedKey), viper.GetFloat64(utils.CWAMaxTPSKey), &service.Stats{},
+	)
+}
 
-	keys := viper.AllKeys()
-	sort.Strings(keys)
-	settings := viper.AllSettings()
+// spool returns the spool for metrics that could not be sent, or nil when disabled or unusable
+func spool() *service.Spool {
+	dir := viper.GetString(utils.CWASpoolDirKey)
+	if dir == "" {
+		return nil
+	}
+	size := int64(viper.GetInt(utils.CWASpoolSizeKey)) << 20
+	sp, err := service.NewSpool(dir, size, viper.GetDuration(utils.CWASpoolMaxAgeKey))
+	if err != nil {
+		log.Printf("spool disabled: %s", err)
+		return nil
+	}
+	return sp
+}
+
+// chosen returns a slice of metrics chosen by flag or by configuration file
+func chosen(collected config.Collected, id identity.Identity, tags tagger) (cm []collector) {
 
-	for _, k := range keys {
-		if !strings.HasPrefix(k, KeyPrefix) || settings[k] == false {
+	enabled := make(map[string]config.Plugin)
+
+	settings := viper.AllSettings()
+	for k, v := range settings {
+		if !strings.HasPrefix(k, KeyPrefix) || v == false {
 			continue
 		}
-		if val, ok := registered[strings.TrimPrefix(k, KeyPrefix)]; ok {
-			cm = append(cm, val)
-			log.Printf("selected %v: %+v", k, val)
+		enabled[strings.TrimPrefix(k, KeyPrefix)] = config.Plugin{}
+	}
+	for key, p := range plugins(collected) {
+		if p != nil {
+			enabled[key] = *p
+		}
+	}
+
+	keys := make([]string, 0, len(enabled))
+	for k := range enabled {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if fn, ok := registered[key]; ok {
+			p := enabled[key]
+			val := fn(p)
+			cm = append(cm, newCollector(key, val, p, id, tags))
+			log.Printf("selected %v: %T", key, val)
 		}
 	}
 
 	return
 }
 
-// collect enabled metrics
-func collect(metrics []Gatherer, dc ec2metadata.EC2InstanceIdentityDocument, cw service.CloudWatch, namespace string) {
-	for _, m := range metrics {
-		m.Collect(dc, cw, namespace)
+// ec2Tags returns the cached tags of the instance when a dimension refers to one, or nil
+func ec2Tags(cf aws.Config, md service.EC2MetaData, id identity.Identity, metrics config.Metrics) tagger {
+	var settings = []map[string]string{metrics.AppendDimensions}
+	for _, p := range plugins(metrics.Collected) {
+		if p != nil {
+			settings = append(settings, p.AppendDimensions)
+		}
+	}
+	if !tagged(settings...) {
+		return nil
+	}
+	if id.InstanceID == "" {
+		log.Printf("tags - not on ec2, dimensions from tags are left out")
+		return nil
 	}
+	// tags are read in the region of the instance, not the one metrics are published to
+	cf.Region = id.Region
+	return service.NewEC2Tags(cf, md, id.InstanceID, viper.GetDuration(utils.CWATagsRefreshKey))
 }
 
-// forever will forever collect metrics unless interrupted
-func forever(ctx context.Context, dc ec2metadata.EC2InstanceIdentityDocument, cm []Gatherer, cw service.CloudWatch, ns string) {
-	var tt = time.NewTicker(time.Duration(viper.GetInt(utils.CWAIntervalKey)) * time.Minute)
-	{
-	loop:
-		for {
-			select {
-			case <-tt.C:
-				collect(cm, dc, cw, ns)
-			case <-ctx.Done():
-				log.Printf("ok stopping forever task due to: %s...", ctx.Err())
-				break loop
-			}
+// clusterName returns the name of the kubernetes cluster of the node from the tags of the instance,
+// read even when no dimension refers to a tag
+func clusterName(cf aws.Config, md service.EC2MetaData, id identity.Identity, tags tagger) (string, error) {
+	if tags == nil {
+		if id.InstanceID == "" {
+			return "", errors.New("kubernetes - not on ec2, set the cluster name with --cluster-name")
 		}
+		cf.Region = id.Region
+		tags = service.NewEC2Tags(cf, md, id.InstanceID, viper.GetDuration(utils.CWATagsRefreshKey))
+	}
+	if name := tagClusterName(tags.Tags()); name != "" {
+		return name, nil
+	}
+	return "", fmt.Errorf("kubernetes - no %s or %s<name> instance tag, set the cluster name with --cluster-name",
+		K8sClusterTag, k8sClusterTagPrefix)
+}
+
+// hostDimensions returns the dimensions that identify this host,
+// `InstanceId` on ec2 and `host` elsewhere, along with the image and instance type when known
+func hostDimensions(id identity.Identity) (dims []Dimension) {
+	if id.InstanceID != "" {
+		dims = append(dims, Dimension{Name: "InstanceId", Value: id.InstanceID})
+	} else {
+		dims = append(dims, Dimension{Name: "host", Value: id.Host})
+	}
+	if id.ImageID != "" {
+		dims = append(dims, Dimension{Name: "ImageId", Value: id.ImageID})
+	}
+	if id.InstanceType != "" {
+		dims = append(dims, Dimension{Name: "InstanceType", Value: id.InstanceType})
+	}
+	return
+}
+
+// interval returns the time between two collections
+func interval() time.Duration {
+	d, err := utils.ParseInterval(viper.GetString(utils.CWAIntervalKey))
+	if err != nil || d <= 0 {
+		log.Fatalf("invalid interval %q: %v", viper.GetString(utils.CWAIntervalKey), err)
 	}
-	log.Println("shutdwon completed.")
+	return d
 }
@@ -0,0 +1,33 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/slatunje/aws-cwa-metric/pkg/config"
+	"github.com/slatunje/aws-cwa-metric/pkg/identity"
+	"github.com/slatunje/aws-cwa-metric/pkg/service"
+)
+
+func TestEC2TagsRegion(t *testing.T) {
+	metrics := config.Metrics{AppendDimensions: map[string]string{"Service": "${aws:tag/Service}"}}
+	id := identity.Identity{InstanceID: "i-0123456789abcdef0", Region: "us-east-2"}
+
+	// metrics are published to eu-west-1 but the instance lives in us-east-2
+	tags, ok := ec2Tags(aws.Config{Region: "eu-west-1"}, service.EC2MetaData{}, id, metrics).(*service.EC2Tags)
+	if !ok {
+		t.Fatal("expected tags when a dimension refers to one")
+	}
+	if got := tags.Client.Config.Region; got != "us-east-2" {
+		t.Errorf("tags read in %s, want the region of the instance", got)
+	}
+
+	if ec2Tags(aws.Config{}, service.EC2MetaData{}, id, config.Metrics{}) != nil {
+		t.Error("expected no tags without a dimension referring to one")
+	}
+	if ec2Tags(aws.Config{}, service.EC2MetaData{}, identity.Identity{}, metrics) != nil {
+		t.Error("expected no tags outside ec2")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/metrics-collected-by-CloudWatch-agent.html
+const (
+	NetstatTCPEstablished = "netstat_tcp_established"
+	NetstatTCPSynSent     = "netstat_tcp_syn_sent"
+	NetstatTCPSynRecv     = "netstat_tcp_syn_recv"
+	NetstatTCPFinWait1    = "netstat_tcp_fin_wait1"
+	NetstatTCPFinWait2    = "netstat_tcp_fin_wait2"
+	NetstatTCPTimeWait    = "netstat_tcp_time_wait"
+	NetstatTCPClose       = "netstat_tcp_close"
+	NetstatTCPCloseWait   = "netstat_tcp_close_wait"
+	NetstatTCPLastAck     = "netstat_tcp_last_ack"
+	NetstatTCPListen      = "netstat_tcp_listen"
+	NetstatTCPClosing     = "netstat_tcp_closing"
+	NetstatUDPSocket      = "netstat_udp_socket"
+)
+
+// tcpStates names the states of the st column of /proc/net/tcp, in the order of include/net/tcp_states.h
+var tcpStates = []string{
+	NetstatTCPEstablished, NetstatTCPSynSent, NetstatTCPSynRecv, NetstatTCPFinWait1, NetstatTCPFinWait2,
+	NetstatTCPTimeWait, NetstatTCPClose, NetstatTCPCloseWait, NetstatTCPLastAck, NetstatTCPListen, NetstatTCPClosing,
+}
+
+// Netstat metric entity, sockets are read from net/tcp, net/tcp6, net/udp and net/udp6 below Proc,
+// the sockets whose local port is one of Ports are also counted per port
+type Netstat struct {
+	Proc  string
+	Ports []int
+}
+
+// Gather the number of TCP sockets per state and of UDP sockets, in total and per port
+func (c Netstat) Gather(ctx context.Context) (samples []Sample, err error) {
+	proc := c.Proc
+	if proc == "" {
+		proc = ProcRoot
+	}
+
+	var ports = make(map[int]bool)
+	for _, p := range c.Ports {
+		ports[p] = true
+	}
+
+	// counts per metric of all sockets (port 0) and of each port
+	var counts = map[int]map[string]float64{0: {}}
+	for p := range ports {
+		counts[p] = make(map[string]float64)
+	}
+	var socket = func(name string, port int) {
+		counts[0][name]++
+		if ports[port] {
+			counts[port][name]++
+		}
+	}
+
+	var errs Errors
+	var found bool
+	for _, file := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		err := readSockets(filepath.Join(proc, "net", file), func(port int, state int) {
+			if strings.HasPrefix(file, "udp") {
+				socket(NetstatUDPSocket, port)
+			} else if state >= 1 && state <= len(tcpStates) {
+				socket(tcpStates[state-1], port)
+			}
+		})
+		switch {
+		case err == nil:
+			found = true
+		case !os.IsNotExist(err):
+			// ipv6 may be disabled, which leaves out tcp6 and udp6
+			errs = append(errs, err)
+		}
+	}
+	if !found {
+		return nil, errs.Err()
+	}
+
+	var add = func(values map[string]float64, dime []Dimension) {
+		for _, name := range append(append([]string{}, tcpStates...), NetstatUDPSocket) {
+			samples = append(samples, Sample{Name: name, Value: values[name], Unit: UnitCount, Dimensions: dime})
+		}
+	}
+	add(counts[0], nil)
+	for _, p := range c.Ports {
+		add(counts[p], []Dimension{{Name: "port", Value: strconv.Itoa(p)}})
+	}
+
+	log.Printf("netstat - established:%v time_wait:%v close_wait:%v udp:%v\n",
+		counts[0][NetstatTCPEstablished], counts[0][NetstatTCPTimeWait], counts[0][NetstatTCPCloseWait], counts[0][NetstatUDPSocket],
+	)
+
+	return samples, errs.Err()
+}
+
+// ParsePorts returns the ports set by flag or, as a list separated by commas, by the environment
+// (i.e. AWS_CWA_NETSTAT_PORTS=80,443)
+func ParsePorts(v interface{}) ([]int, error) {
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case []int:
+		return t, nil
+	case string:
+		var ports []int
+		for _, f := range strings.FieldsFunc(t, func(r rune) bool { return r == ',' || r == ' ' }) {
+			port, err := strconv.Atoi(f)
+			if err != nil || port <= 0 || port > 65535 {
+				return nil, fmt.Errorf("invalid port %q in %q", f, t)
+			}
+			ports = append(ports, port)
+		}
+		return ports, nil
+	}
+	return nil, fmt.Errorf("ports %v are not a list of numbers", v)
+}
+
+// readSockets calls fn with the local port and state of each socket of a /proc/net file
+// (i.e. `0: 0100007F:0CEA 00000000:0000 0A ...`)
+func readSockets(path string, fn func(port, state int)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		local := fields[1]
+		port, err := strconv.ParseInt(local[strings.LastIndexByte(local, ':')+1:], 16, 32)
+		if err != nil {
+			continue
+		}
+		state, err := strconv.ParseInt(fields[3], 16, 32)
+		if err != nil {
+			continue
+		}
+		fn(int(port), int(state))
+	}
+	return scanner.Err()
+}
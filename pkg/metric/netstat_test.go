@@ -0,0 +1,128 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want []int
+		err  bool
+	}{
+		{nil, nil, false},
+		{[]int{80, 443}, []int{80, 443}, false},
+		{"80,443", []int{80, 443}, false},
+		{"80, 443 8080", []int{80, 443, 8080}, false},
+		{"", nil, false},
+		{"80,http", nil, true},
+		{"70000", nil, true},
+		{3.5, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePorts(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: error = %v", tt.in, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: ports = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadSockets(t *testing.T) {
+	type socket struct{ port, state int }
+	var got []socket
+	err := readSockets(filepath.Join(testProc, "net", "tcp"), func(port, state int) {
+		got = append(got, socket{port, state})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the header, a short line and a line with an invalid port are skipped, the remote port is not used
+	want := []socket{{80, 0x0A}, {3306, 0x0A}, {80, 0x01}, {80, 0x06}, {41394, 0x01}, {41396, 0x08}, {41398, 0x0C}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sockets = %v, want %v", got, want)
+	}
+	if err := readSockets(filepath.Join(testProc, "net", "udp6"), func(int, int) {}); !os.IsNotExist(err) {
+		t.Errorf("err = %v, want not exist", err)
+	}
+}
+
+func TestNetstatGather(t *testing.T) {
+	samples, err := Netstat{Proc: testProc, Ports: []int{80, 443, 53, 8080}}.Gather(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]map[string]float64)
+	for _, s := range samples {
+		var port string
+		if len(s.Dimensions) > 0 {
+			port = s.Dimensions[0].Value
+		}
+		if got[port] == nil {
+			got[port] = make(map[string]float64)
+		}
+		if s.Value != 0 {
+			got[port][s.Name] = s.Value
+		}
+	}
+	// tcp and tcp6 are summed, udp6 is missing as when ipv6 is disabled and an unknown state is left out
+	want := map[string]map[string]float64{
+		"": {
+			NetstatTCPEstablished: 3, NetstatTCPListen: 3, NetstatTCPTimeWait: 1, NetstatTCPCloseWait: 1,
+			NetstatUDPSocket: 2,
+		},
+		"80":   {NetstatTCPEstablished: 1, NetstatTCPListen: 1, NetstatTCPTimeWait: 1},
+		"443":  {NetstatTCPEstablished: 1, NetstatTCPListen: 1},
+		"53":   {NetstatUDPSocket: 1},
+		"8080": {},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sockets = %v, want %v", got, want)
+	}
+	// every state is published, in total and per port
+	if n := (len(tcpStates) + 1) * 5; len(samples) != n {
+		t.Errorf("%d samples, want %d", len(samples), n)
+	}
+}
+
+func TestNetstatGatherErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// without any socket file there is nothing to publish
+	if samples, err := (Netstat{Proc: dir}).Gather(context.Background()); err != nil || samples != nil {
+		t.Errorf("samples = %v, %v, want none", samples, err)
+	}
+
+	// a file that cannot be read is reported along with the sockets of the others
+	net := filepath.Join(dir, "net")
+	if err := os.MkdirAll(filepath.Join(net, "tcp"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(testProc, "net", "udp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(net, "udp"), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	samples, err := Netstat{Proc: dir}.Gather(context.Background())
+	if err == nil {
+		t.Error("expected an error for tcp")
+	}
+	if got := values(t, samples); got[NetstatUDPSocket] != 2 {
+		t.Errorf("samples = %v, want 2 udp sockets", got)
+	}
+}
@@ -3,12 +3,11 @@
 package metric
 
 import (
+	"context"
 	"log"
 
-	"github.com/aws/aws-sdk-go-v2/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	"github.com/shirou/gopsutil/net"
-	"github.com/slatunje/aws-cwa-metric/pkg/service"
+	"github.com/slatunje/aws-cwa-metric/pkg/config"
 )
 
 // https://github.com/shirou/gopsutil/blob/master/net/net.go#L17
@@ -24,57 +23,92 @@ const (
 	NetworkDropOut   = "net_drop_out"
 )
 
+// NetworkTotal is the interface of the series summed over the selected interfaces
+const NetworkTotal = "all"
+
+// NetworkIgnoreInterfaces are left out unless ignore_interfaces is set: loopback, bridges and container veths
+var NetworkIgnoreInterfaces = []string{"lo", "docker*", "veth*", "cni*", "flannel*", "cali*", "br-*", "virbr*"}
+
 // Network metric entity
-type Network struct{}
+type Network struct {
+	Interfaces   Filter
+	PerInterface bool
+	Total        bool
+}
+
+// NewNetwork returns an instance of `Network` selecting interfaces with the filters of p
+func NewNetwork(p config.Plugin, perInterface, total bool) (n Network, err error) {
+	ignore := p.IgnoreInterfaces
+	if ignore == nil {
+		ignore = NetworkIgnoreInterfaces
+	}
+	n.Interfaces, err = NewFilter(p.Interfaces, ignore)
+	n.PerInterface, n.Total = perInterface, total
+	return
+}
 
-// Collect Network Traffic metrics
-func (c Network) Collect(doc ec2metadata.EC2InstanceIdentityDocument, cw service.CloudWatch, namespace string) {
-	metrics, err := net.IOCounters(false)
+// Gather Network Traffic metrics
+func (c Network) Gather(ctx context.Context) (samples []Sample, err error) {
+	metrics, err := net.IOCountersWithContext(ctx, true)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	return c.samples(metrics), nil
+}
 
-	var publish = func(name string, value float64, unit cloudwatch.StandardUnit, dime []cloudwatch.Dimension) {
-		cw.Publish(NewDatum(name, value, unit, dime), namespace)
+// samples returns the counters of the selected interfaces, each on its own and summed per the settings of c
+func (c Network) samples(metrics []net.IOCountersStat) (samples []Sample) {
+	var count = func(ioc net.IOCountersStat) {
+		dime := []Dimension{
+			{
+				Name:  "interface",
+				Value: ioc.Name,
+			},
+		}
+		for _, s := range []Sample{
+			{Name: NetworkBytesIn, Value: float64(ioc.BytesRecv), Unit: UnitBytes},
+			{Name: NetworkBytesOut, Value: float64(ioc.BytesSent), Unit: UnitBytes},
+			{Name: NetworkPacketIn, Value: float64(ioc.PacketsRecv), Unit: UnitCount},
+			{Name: NetworkPacketOut, Value: float64(ioc.PacketsSent), Unit: UnitCount},
+			{Name: NetworkErrorsIn, Value: float64(ioc.Errin), Unit: UnitCount},
+			{Name: NetworkErrorsOut, Value: float64(ioc.Errout), Unit: UnitCount},
+			{Name: NetworkDropIn, Value: float64(ioc.Dropin), Unit: UnitCount},
+			{Name: NetworkDropOut, Value: float64(ioc.Dropout), Unit: UnitCount},
+		} {
+			s.Dimensions, s.Counter = dime, true
+			samples = append(samples, s)
+		}
 	}
 
+	var total = net.IOCountersStat{Name: NetworkTotal}
+
 	for _, ioc := range metrics {
 
-		key1 := "InstanceId"
-		key2 := "ImageId"
-		key3 := "InstanceType"
-		key4 := "IOCounter"
+		if !c.Interfaces.Match(ioc.Name) {
+			continue
+		}
 
-		dime := []cloudwatch.Dimension{
-			{
-				Name:  &key1,
-				Value: &doc.InstanceID,
-			},
-			{
-				Name:  &key2,
-				Value: &doc.ImageID,
-			},
-			{
-				Name:  &key3,
-				Value: &doc.InstanceType,
-			},
-			{
-				Name:  &key4,
-				Value: &ioc.Name,
-			},
+		if c.PerInterface {
+			count(ioc)
 		}
 
-		publish(NetworkBytesIn, float64(ioc.BytesRecv), cloudwatch.StandardUnitBytes, dime)
-		publish(NetworkBytesOut, float64(ioc.BytesSent), cloudwatch.StandardUnitBytes, dime)
-		publish(NetworkPacketIn, float64(ioc.PacketsRecv), cloudwatch.StandardUnitCount, dime)
-		publish(NetworkPacketOut, float64(ioc.PacketsSent), cloudwatch.StandardUnitCount, dime)
-		publish(NetworkErrorsIn, float64(ioc.Errin), cloudwatch.StandardUnitCount, dime)
-		publish(NetworkErrorsOut, float64(ioc.Errout), cloudwatch.StandardUnitCount, dime)
-		publish(NetworkDropIn, float64(ioc.Dropin), cloudwatch.StandardUnitCount, dime)
-		publish(NetworkDropOut, float64(ioc.Dropout), cloudwatch.StandardUnitCount, dime)
+		total.BytesRecv += ioc.BytesRecv
+		total.BytesSent += ioc.BytesSent
+		total.PacketsRecv += ioc.PacketsRecv
+		total.PacketsSent += ioc.PacketsSent
+		total.Errin += ioc.Errin
+		total.Errout += ioc.Errout
+		total.Dropin += ioc.Dropin
+		total.Dropout += ioc.Dropout
 
 		log.Printf("network - %s bytes in/out: %v/%v packets in/out: %v/%v errors in/out: %v/%v\n",
-			ioc.Name, ioc.BytesRecv, ioc.BytesSent, ioc.Errin, ioc.Errout, ioc.PacketsRecv, ioc.PacketsSent,
+			ioc.Name, ioc.BytesRecv, ioc.BytesSent, ioc.PacketsRecv, ioc.PacketsSent, ioc.Errin, ioc.Errout,
 		)
 	}
+
+	if c.Total {
+		count(total)
+	}
+
+	return samples
 }
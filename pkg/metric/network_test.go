@@ -0,0 +1,79 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shirou/gopsutil/net"
+	"github.com/slatunje/aws-cwa-metric/pkg/config"
+)
+
+func TestNetworkSamples(t *testing.T) {
+	counters := []net.IOCountersStat{
+		{Name: "lo", BytesRecv: 1000, BytesSent: 1000},
+		{Name: "eth0", BytesRecv: 100, BytesSent: 10, PacketsRecv: 5, Errin: 1, Dropout: 2},
+		{Name: "eth1", BytesRecv: 200, BytesSent: 20, PacketsRecv: 7, Errin: 3, Dropout: 4},
+		{Name: "docker0", BytesRecv: 5000},
+		{Name: "veth1a2b3c", BytesRecv: 5000},
+	}
+	tests := []struct {
+		name         string
+		plugin       config.Plugin
+		perInterface bool
+		total        bool
+		want         map[string]float64 // bytes received per interface
+	}{
+		{"per interface", config.Plugin{}, true, false, map[string]float64{"eth0": 100, "eth1": 200}},
+		{"total", config.Plugin{}, false, true, map[string]float64{NetworkTotal: 300}},
+		{"both", config.Plugin{}, true, true, map[string]float64{"eth0": 100, "eth1": 200, NetworkTotal: 300}},
+		{"neither", config.Plugin{}, false, false, map[string]float64{}},
+		{"interfaces", config.Plugin{Interfaces: []string{"eth1"}}, true, true, map[string]float64{"eth1": 200, NetworkTotal: 200}},
+		{"ignore replaced", config.Plugin{IgnoreInterfaces: []string{"eth*"}}, false, true, map[string]float64{NetworkTotal: 11000}},
+		{"ignore cleared", config.Plugin{IgnoreInterfaces: []string{}}, false, true, map[string]float64{NetworkTotal: 11300}},
+		{"nothing selected", config.Plugin{Interfaces: []string{"ens*"}}, true, true, map[string]float64{NetworkTotal: 0}},
+	}
+	for _, tt := range tests {
+		n, err := NewNetwork(tt.plugin, tt.perInterface, tt.total)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := make(map[string]float64)
+		for _, s := range n.samples(counters) {
+			if !s.Counter || len(s.Dimensions) != 1 || s.Dimensions[0].Name != "interface" {
+				t.Errorf("%s: sample = %+v", tt.name, s)
+			}
+			if s.Name == NetworkBytesIn {
+				got[s.Dimensions[0].Value] = s.Value
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: bytes received = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNetworkTotal(t *testing.T) {
+	n, err := NewNetwork(config.Plugin{}, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := n.samples([]net.IOCountersStat{
+		{Name: "eth0", BytesRecv: 100, BytesSent: 10, PacketsRecv: 5, PacketsSent: 1, Errin: 1, Errout: 2, Dropin: 3, Dropout: 4},
+		{Name: "eth1", BytesRecv: 200, BytesSent: 20, PacketsRecv: 7, PacketsSent: 2, Errin: 3, Errout: 4, Dropin: 5, Dropout: 6},
+	})
+	want := map[string]float64{
+		NetworkBytesIn: 300, NetworkBytesOut: 30, NetworkPacketIn: 12, NetworkPacketOut: 3,
+		NetworkErrorsIn: 4, NetworkErrorsOut: 6, NetworkDropIn: 8, NetworkDropOut: 10,
+	}
+	if got := values(t, samples); !reflect.DeepEqual(got, want) {
+		t.Errorf("total = %v, want %v", got, want)
+	}
+	units := map[string]Unit{NetworkBytesIn: UnitBytes, NetworkBytesOut: UnitBytes, NetworkPacketIn: UnitCount}
+	for _, s := range samples {
+		if u, ok := units[s.Name]; ok && s.Unit != u {
+			t.Errorf("%s: unit = %s, want %s", s.Name, s.Unit, u)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slatunje/aws-cwa-metric/pkg/config"
+	"github.com/slatunje/aws-cwa-metric/pkg/identity"
+	"github.com/slatunje/aws-cwa-metric/pkg/utils"
+)
+
+// prefixes of the metric names of each key, a measurement may leave them out (i.e. usage_idle)
+var prefixes = map[string]string{
+	KeyCPU:      "cpu_",
+	KeyDisk:     "disk_",
+	KeyDiskIO:   "diskio_",
+	KeyDocker:   "docker_",
+	KeyECS:      "ecs_",
+	KeyK8s:      "k8s_",
+	KeyProcstat: "procstat_",
+	KeyMemory:   "mem_",
+	KeyNetstat:  "netstat_",
+	KeyNetwork:  "net_",
+	KeySwap:     "swap_",
+	KeySystem:   "system_",
+}
+
+// resources names the dimension selected by the `resources` setting of each key
+var resources = map[string]string{
+	KeyCPU:     "cpu",
+	KeyDisk:    "path",
+	KeyDiskIO:  "IOCounter",
+	KeyNetwork: "interface",
+}
+
+// plugins maps the CloudWatch Agent plugins onto the registered keys
+func plugins(c config.Collected) map[string]*config.Plugin {
+	return map[string]*config.Plugin{
+		KeyCPU:      c.CPU,
+		KeyDisk:     c.Disk,
+		KeyDiskIO:   c.DiskIO,
+		KeyDocker:   c.Docker,
+		KeyECS:      c.ECS,
+		KeyK8s:      c.Kubernetes,
+		KeyProcstat: c.Procstat,
+		KeyMemory:   c.Mem,
+		KeyNetstat:  c.Netstat,
+		KeyNetwork:  c.Net,
+		KeySwap:     c.Swap,
+		KeySystem:   c.System,
+	}
+}
+
+// collector is a chosen Gatherer with the plugin settings of the key it is registered with
+type collector struct {
+	key        string
+	interval   time.Duration
+	highRes    bool
+	measures   map[string]config.Measurement
+	resources  map[string]bool
+	dimensions appender
+	deltas     *Deltas
+	Gatherer
+}
+
+// newCollector returns a collector for a Gatherer configured by p
+func newCollector(key string, g Gatherer, p config.Plugin, id identity.Identity, tags tagger) collector {
+	c := collector{key: key, interval: interval(), Gatherer: g}
+	if p.Interval > 0 {
+		c.interval = time.Duration(p.Interval) * time.Second
+	}
+	c.highRes = c.interval < utils.HighResolution
+	if p.HighResolution != nil {
+		c.highRes = *p.HighResolution
+	}
+	if len(p.Measurement) > 0 {
+		c.measures = make(map[string]config.Measurement)
+		for _, m := range p.Measurement {
+			name := m.Name
+			if !strings.HasPrefix(name, prefixes[key]) {
+				name = prefixes[key] + name
+			}
+			c.measures[name] = m
+		}
+	}
+	if len(p.Resources) > 0 && !all(p.Resources) {
+		c.resources = make(map[string]bool)
+		for _, r := range p.Resources {
+			c.resources[r] = true
+		}
+	}
+	c.dimensions = appender{dims: p.AppendDimensions, id: id, tags: tags}
+	return c
+}
+
+// measure keeps the samples selected by the plugin settings, then renames them, appends dimensions
+// and sets their storage resolution
+func (c collector) measure(samples []Sample) (out []Sample) {
+	var dimensions = c.dimensions.resolve()
+	for _, s := range samples {
+		if c.measures != nil {
+			m, ok := c.measures[s.Name]
+			if !ok {
+				continue
+			}
+			if m.Rename != "" {
+				s.Name = m.Rename
+			}
+			if m.Unit != "" {
+				s.Unit = Unit(m.Unit)
+			}
+			s.Distribution = s.Distribution || m.Distribution
+		}
+		if c.resources != nil && !c.resources[value(s.Dimensions, resources[c.key])] {
+			continue
+		}
+		if len(dimensions) > 0 {
+			s.Dimensions = append(append([]Dimension{}, s.Dimensions...), dimensions...)
+		}
+		s.HighResolution = c.highRes
+		out = append(out, s)
+	}
+	return
+}
+
+// placeholders resolves the `append_dimensions` values understood by the CloudWatch Agent
+var placeholders = map[string]func(identity.Identity) string{
+	"${aws:InstanceId}":   func(id identity.Identity) string { return id.InstanceID },
+	"${aws:ImageId}":      func(id identity.Identity) string { return id.ImageID },
+	"${aws:InstanceType}": func(id identity.Identity) string { return id.InstanceType },
+}
+
+// tag placeholders, the auto scaling group name is the tag set by ec2 auto scaling
+const (
+	tagPrefix        = "${aws:tag/"
+	autoScalingGroup = "${aws:AutoScalingGroupName}"
+	autoScalingTag   = "aws:autoscaling:groupName"
+)
+
+// tagger returns the tags of the instance
+type tagger interface {
+	Tags() map[string]string
+}
+
+// appender resolves an `append_dimensions` setting, on every collection so changes to tags are picked up
+type appender struct {
+	dims map[string]string
+	id   identity.Identity
+	tags tagger
+}
+
+// resolve returns the dimensions sorted by name, leaving out those without a value
+func (a appender) resolve() (out []Dimension) {
+	for _, name := range sortedKeys(a.dims) {
+		v := a.dims[name]
+		if strings.HasPrefix(v, "${") {
+			fn, ok := placeholders[v]
+			key, tag := tagKey(v)
+			switch {
+			case ok:
+				v = fn(a.id)
+			case tag && a.tags != nil:
+				v = a.tags.Tags()[key]
+			case tag:
+				v = ""
+			default:
+				log.Printf("dimension %s: unsupported value %s", name, v)
+				continue
+			}
+			if v == "" {
+				continue
+			}
+		}
+		out = append(out, Dimension{Name: name, Value: v})
+	}
+	return
+}
+
+// tagKey returns the tag key a placeholder refers to (i.e. `${aws:tag/Service}`)
+func tagKey(v string) (string, bool) {
+	if v == autoScalingGroup {
+		return autoScalingTag, true
+	}
+	if strings.HasPrefix(v, tagPrefix) && strings.HasSuffix(v, "}") && len(v) > len(tagPrefix)+1 {
+		return v[len(tagPrefix) : len(v)-1], true
+	}
+	return "", false
+}
+
+// tagged reports whether any of the settings refers to a tag
+func tagged(settings ...map[string]string) bool {
+	for _, dims := range settings {
+		for _, v := range dims {
+			if _, ok := tagKey(v); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// value returns the value of the dimension called name
+func value(dims []Dimension, name string) string {
+	for _, d := range dims {
+		if d.Name == name {
+			return d.Value
+		}
+	}
+	return ""
+}
+
+// all reports whether resources selects everything
+func all(resources []string) bool {
+	for _, r := range resources {
+		if r == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
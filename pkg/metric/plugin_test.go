@@ -0,0 +1,91 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/slatunje/aws-cwa-metric/pkg/config"
+	"github.com/slatunje/aws-cwa-metric/pkg/identity"
+	"github.com/slatunje/aws-cwa-metric/pkg/utils"
+	"github.com/spf13/viper"
+)
+
+func TestMeasureResources(t *testing.T) {
+	viper.Set(utils.CWAIntervalKey, "1m")
+
+	var sample = func(name, dimension, value string) Sample {
+		return Sample{Name: name, Dimensions: []Dimension{{Name: dimension, Value: value}}}
+	}
+	tests := []struct {
+		key       string
+		resources []string
+		samples   []Sample
+		want      []string
+	}{
+		{
+			key:       KeyCPU,
+			resources: []string{"cpu0"},
+			samples:   []Sample{sample(CPUUsageIdle, "cpu", "cpu0"), sample(CPUUsageIdle, "cpu", "cpu1")},
+			want:      []string{"cpu0"},
+		},
+		{
+			key:       KeyCPU,
+			resources: []string{"*"},
+			samples:   []Sample{sample(CPUUsageIdle, "cpu", "cpu0"), sample(CPUUsageIdle, "cpu", "cpu-total")},
+			want:      []string{"cpu0", "cpu-total"},
+		},
+		{
+			key:       KeyDisk,
+			resources: []string{"/"},
+			samples:   []Sample{sample("disk_used_percent", "path", "/"), sample("disk_used_percent", "path", "/data")},
+			want:      []string{"/"},
+		},
+		{
+			key:       KeyDiskIO,
+			resources: []string{"nvme0n1"},
+			samples:   []Sample{sample(DiskUtil, "IOCounter", "nvme0n1"), sample(DiskUtil, "IOCounter", "nvme1n1")},
+			want:      []string{"nvme0n1"},
+		},
+		{
+			key:       KeyNetwork,
+			resources: []string{"eth0"},
+			samples:   []Sample{sample("net_bytes_recv", "interface", "eth0"), sample("net_bytes_recv", "interface", "lo")},
+			want:      []string{"eth0"},
+		},
+	}
+	for _, tt := range tests {
+		c := newCollector(tt.key, nil, config.Plugin{Resources: tt.resources}, identity.Identity{}, nil)
+		var got []string
+		for _, s := range c.measure(tt.samples) {
+			got = append(got, s.Dimensions[0].Value)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %v: kept %v, want %v", tt.key, tt.resources, got, tt.want)
+		}
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	viper.Set(utils.CWAIntervalKey, "1m")
+
+	p := config.Plugin{
+		Measurement:      []config.Measurement{{Name: "usage_idle"}, {Name: "cpu_usage_active", Rename: "CPU_ACTIVE", Unit: "None"}},
+		AppendDimensions: map[string]string{"InstanceId": "${aws:InstanceId}", "Team": "core"},
+	}
+	c := newCollector(KeyCPU, nil, p, identity.Identity{InstanceID: "i-0123456789abcdef0"}, nil)
+	got := c.measure([]Sample{
+		{Name: CPUUsageIdle, Value: 90, Unit: UnitPercent},
+		{Name: CPUUsageActive, Value: 10, Unit: UnitPercent},
+		{Name: CPUUsageSystem, Value: 5, Unit: UnitPercent},
+	})
+	dims := []Dimension{{Name: "InstanceId", Value: "i-0123456789abcdef0"}, {Name: "Team", Value: "core"}}
+	want := []Sample{
+		{Name: CPUUsageIdle, Value: 90, Unit: UnitPercent, Dimensions: dims},
+		{Name: "CPU_ACTIVE", Value: 10, Unit: Unit("None"), Dimensions: dims},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("measure = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+	"github.com/slatunje/aws-cwa-metric/pkg/config"
+)
+
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch-Agent-procstat-process-metrics.html
+const (
+	ProcstatLookupPidCount = "procstat_lookup_pid_count"
+	ProcstatPidCount       = "procstat_pid_count"
+	ProcstatCPUUsage       = "procstat_cpu_usage"
+	ProcstatMemoryRSS      = "procstat_memory_rss"
+	ProcstatMemoryVMS      = "procstat_memory_vms"
+	ProcstatNumFDs         = "procstat_num_fds"
+	ProcstatNumThreads     = "procstat_num_threads"
+	ProcstatReadBytes      = "procstat_read_bytes"
+	ProcstatWriteBytes     = "procstat_write_bytes"
+	ProcstatUptime         = "procstat_uptime"
+)
+
+// ProcessGroup is a set of processes selected by Match, published with the dimension Name=Value,
+// Measurement selects, renames or changes the unit of its metrics as the measurement of a plugin does
+type ProcessGroup struct {
+	Name        string
+	Value       string
+	Match       func(ctx context.Context, all []*process.Process) ([]*process.Process, error)
+	Measurement []config.Measurement
+}
+
+// measure applies the measurement of the group to a sample, reporting whether it is selected
+func (g ProcessGroup) measure(s Sample) (Sample, bool) {
+	if len(g.Measurement) == 0 {
+		return s, true
+	}
+	for _, m := range g.Measurement {
+		name := m.Name
+		if !strings.HasPrefix(name, prefixes[KeyProcstat]) {
+			name = prefixes[KeyProcstat] + name
+		}
+		if name != s.Name {
+			continue
+		}
+		if m.Rename != "" {
+			s.Name = m.Rename
+		}
+		if m.Unit != "" {
+			s.Unit = Unit(m.Unit)
+		}
+		s.Distribution = s.Distribution || m.Distribution
+		return s, true
+	}
+	return s, false
+}
+
+// Procstat metric entity, usage is summed over the processes of each group,
+// the cpu usage is computed between two consecutive gathers where 100% is one cpu
+type Procstat struct {
+	Groups []ProcessGroup
+
+	mu       sync.Mutex
+	previous map[int32]procTimes
+	last     time.Time
+}
+
+// procTimes is the cpu time used by a process, told apart from a later process with the same pid by its start
+type procTimes struct {
+	created int64
+	total   float64
+}
+
+// NewProcstat returns an instance of `Procstat` with a group per process setting
+func NewProcstat(processes []config.Process) (*Procstat, error) {
+	p := &Procstat{previous: make(map[int32]procTimes)}
+	for _, s := range processes {
+		g, err := processGroup(s)
+		if err != nil {
+			return nil, err
+		}
+		g.Measurement = s.Measurement
+		p.Groups = append(p.Groups, g)
+	}
+	return p, nil
+}
+
+// processGroup returns the group selected by a process setting
+func processGroup(s config.Process) (ProcessGroup, error) {
+	var byRegexp = func(name, expr string, field func(context.Context, *process.Process) (string, error)) (ProcessGroup, error) {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return ProcessGroup{}, err
+		}
+		return ProcessGroup{Name: name, Value: expr, Match: func(ctx context.Context, all []*process.Process) (out []*process.Process, err error) {
+			for _, p := range all {
+				if v, err := field(ctx, p); err == nil && re.MatchString(v) {
+					out = append(out, p)
+				}
+			}
+			return
+		}}, nil
+	}
+	var byPids = func(name, value string, read func() ([]int32, error)) ProcessGroup {
+		return ProcessGroup{Name: name, Value: value, Match: func(ctx context.Context, all []*process.Process) (out []*process.Process, err error) {
+			pids, err := read()
+			if err != nil {
+				return nil, err
+			}
+			wanted := make(map[int32]bool)
+			for _, pid := range pids {
+				wanted[pid] = true
+			}
+			for _, p := range all {
+				if wanted[p.Pid] {
+					out = append(out, p)
+				}
+			}
+			return
+		}}
+	}
+
+	switch {
+	case s.Exe != "":
+		return byRegexp("exe", s.Exe, func(ctx context.Context, p *process.Process) (string, error) {
+			return p.NameWithContext(ctx)
+		})
+	case s.Pattern != "":
+		return byRegexp("pattern", s.Pattern, func(ctx context.Context, p *process.Process) (string, error) {
+			return p.CmdlineWithContext(ctx)
+		})
+	case s.PidFile != "":
+		return byPids("pidfile", s.PidFile, func() ([]int32, error) { return readPids(s.PidFile) }), nil
+	case s.SystemdUnit != "":
+		return byPids("systemd_unit", s.SystemdUnit, func() ([]int32, error) { return unitPids(s.SystemdUnit) }), nil
+	}
+	return ProcessGroup{}, fmt.Errorf("procstat: a process needs one of exe, pattern, pid_file or systemd_unit")
+}
+
+// Gather the number, CPU, Memory, File Descriptors, Threads, IO & Uptime of the processes of each group,
+// the pid count of a group is published even when none of its processes runs
+func (c *Procstat) Gather(ctx context.Context) (samples []Sample, err error) {
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var all = make([]*process.Process, 0, len(pids))
+	for _, pid := range pids {
+		if p, err := process.NewProcess(pid); err == nil {
+			all = append(all, p)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var now = time.Now()
+	var elapsed = now.Sub(c.last).Seconds()
+	var first = c.last.IsZero()
+	var current = make(map[int32]procTimes)
+	var errs Errors
+
+	for _, g := range c.Groups {
+
+		dime := []Dimension{{Name: g.Name, Value: g.Value}}
+		var emit = func(s Sample) {
+			if s, ok := g.measure(s); ok {
+				samples = append(samples, s)
+			}
+		}
+		var add = func(name string, value float64, unit Unit) {
+			emit(Sample{Name: name, Value: value, Unit: unit, Dimensions: dime})
+		}
+		var count = func(name string, value float64, unit Unit) {
+			emit(Sample{Name: name, Value: value, Unit: unit, Dimensions: dime, Counter: true})
+		}
+
+		matched, err := g.Match(ctx, all)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		add(ProcstatLookupPidCount, float64(len(matched)), UnitCount)
+		if len(matched) == 0 {
+			continue
+		}
+
+		var cpu, rss, vms, fds, threads, read, write, uptime float64
+		for _, p := range matched {
+			created, err := p.CreateTimeWithContext(ctx)
+			if err != nil {
+				// the process exited since the pids were listed
+				continue
+			}
+			if up := now.Sub(time.Unix(0, created*int64(time.Millisecond))).Seconds(); up > uptime {
+				uptime = up
+			}
+			if t, err := p.TimesWithContext(ctx); err == nil {
+				cur := procTimes{created: created, total: t.User + t.System}
+				current[p.Pid] = cur
+				if prev, ok := c.previous[p.Pid]; ok && prev.created == cur.created && cur.total >= prev.total {
+					cpu += cur.total - prev.total
+				} else if !first && created >= c.last.UnixNano()/int64(time.Millisecond) {
+					cpu += cur.total
+				}
+			}
+			if m, err := p.MemoryInfoWithContext(ctx); err == nil {
+				rss += float64(m.RSS)
+				vms += float64(m.VMS)
+			}
+			if n, err := p.NumFDsWithContext(ctx); err == nil {
+				fds += float64(n)
+			}
+			if n, err := p.NumThreadsWithContext(ctx); err == nil {
+				threads += float64(n)
+			}
+			if io, err := p.IOCountersWithContext(ctx); err == nil {
+				read += float64(io.ReadBytes)
+				write += float64(io.WriteBytes)
+			}
+		}
+
+		add(ProcstatPidCount, float64(len(matched)), UnitCount)
+		if !first && elapsed > 0 {
+			add(ProcstatCPUUsage, 100*cpu/elapsed, UnitPercent)
+		}
+		add(ProcstatMemoryRSS, rss, UnitBytes)
+		add(ProcstatMemoryVMS, vms, UnitBytes)
+		add(ProcstatNumFDs, fds, UnitCount)
+		add(ProcstatNumThreads, threads, UnitCount)
+		count(ProcstatReadBytes, read, UnitBytes)
+		count(ProcstatWriteBytes, write, UnitBytes)
+		add(ProcstatUptime, uptime, UnitSeconds)
+
+		log.Printf("procstat - %s:%s pids:%d rss:%v\n", g.Name, g.Value, len(matched), rss)
+	}
+
+	c.previous, c.last = current, now
+	return samples, errs.Err()
+}
+
+// readPids reads the pid written in a pid file
+func readPids(path string) ([]int32, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("pid file %s: %s", path, err)
+	}
+	return []int32{int32(pid)}, nil
+}
+
+// unitPids reads the pids of a systemd unit from the cgroup.procs of its cgroup,
+// in the unified hierarchy or the v1 systemd hierarchy
+func unitPids(unit string) ([]int32, error) {
+	if !strings.Contains(unit, ".") {
+		unit += ".service"
+	}
+	cg, err := MountedCGroups(CGroupMountInfo)
+	if err != nil {
+		return nil, err
+	}
+	root := cg.Unified
+	if root == "" {
+		root = cg.V1["name=systemd"]
+	}
+	b, err := ioutil.ReadFile(filepath.Join(root, "system.slice", unit, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int32
+	for _, line := range strings.Fields(string(b)) {
+		if pid, err := strconv.ParseInt(line, 10, 32); err == nil {
+			pids = append(pids, int32(pid))
+		}
+	}
+	return pids, nil
+}
@@ -0,0 +1,28 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"testing"
+
+	"github.com/slatunje/aws-cwa-metric/pkg/config"
+)
+
+func TestProcessGroupMeasure(t *testing.T) {
+	g := ProcessGroup{Measurement: []config.Measurement{
+		{Name: "cpu_usage"},
+		{Name: "procstat_memory_rss", Rename: "RSS", Unit: "Megabytes"},
+	}}
+	if _, ok := g.measure(Sample{Name: ProcstatCPUUsage}); !ok {
+		t.Error("cpu_usage should be selected without its prefix")
+	}
+	if s, ok := g.measure(Sample{Name: ProcstatMemoryRSS, Unit: UnitBytes}); !ok || s.Name != "RSS" || s.Unit != "Megabytes" {
+		t.Errorf("memory_rss = %+v, %t", s, ok)
+	}
+	if _, ok := g.measure(Sample{Name: ProcstatLookupPidCount}); ok {
+		t.Error("lookup_pid_count should be left out")
+	}
+	if _, ok := (ProcessGroup{}).measure(Sample{Name: ProcstatLookupPidCount}); !ok {
+		t.Error("every metric is selected without measurement")
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/slatunje/aws-cwa-metric/pkg/service"
+)
+
+// rollups returns a copy of a datum for each dimension set it carries all of, keeping only those
+// dimensions in the order of the set. A set is emitted once even when configured twice, and
+// never when it is the same as the dimensions of the datum, an empty set drops every dimension.
+func rollups(d service.Datum, sets [][]string) (data []service.Datum) {
+	var values = make(map[string]string, len(d.Dimensions))
+	for _, dim := range d.Dimensions {
+		values[aws.StringValue(dim.Name)] = aws.StringValue(dim.Value)
+	}
+	var seen = map[string]bool{dimensionKey(d.Dimensions): true}
+	for _, set := range sets {
+		var dims = make([]cloudwatch.Dimension, 0, len(set))
+		for _, name := range set {
+			v, ok := values[name]
+			if !ok {
+				dims = nil
+				break
+			}
+			dims = append(dims, cloudwatch.Dimension{Name: aws.String(name), Value: aws.String(v)})
+		}
+		if dims == nil || seen[dimensionKey(dims)] {
+			continue
+		}
+		seen[dimensionKey(dims)] = true
+		r := d
+		r.Dimensions = dims
+		data = append(data, r)
+	}
+	return
+}
+
+// dimensionKey identifies a set of dimensions regardless of their order
+func dimensionKey(dims []cloudwatch.Dimension) string {
+	var names = make([]string, 0, len(dims))
+	for _, dim := range dims {
+		names = append(names, aws.StringValue(dim.Name)+"="+aws.StringValue(dim.Value))
+	}
+	sort.Strings(names)
+	return strings.Join(names, "\x00")
+}
@@ -0,0 +1,106 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/slatunje/aws-cwa-metric/pkg/service"
+)
+
+// names returns the dimensions of each datum as name=value joined by commas
+func names(data []service.Datum) (out []string) {
+	for _, d := range data {
+		var dims []string
+		for _, dim := range d.Dimensions {
+			dims = append(dims, aws.StringValue(dim.Name)+"="+aws.StringValue(dim.Value))
+		}
+		out = append(out, strings.Join(dims, ","))
+	}
+	return
+}
+
+func TestRollups(t *testing.T) {
+	d := NewDatum(Sample{Name: "mem_used_percent", Value: 50}, []Dimension{
+		{Name: "InstanceId", Value: "i-1"},
+		{Name: "AutoScalingGroupName", Value: "web"},
+		{Name: "InstanceType", Value: "m5.large"},
+	})
+	tests := []struct {
+		name string
+		sets [][]string
+		want []string
+	}{
+		{"none", nil, nil},
+		{"single", [][]string{{"AutoScalingGroupName"}}, []string{"AutoScalingGroupName=web"}},
+		{"order of the set", [][]string{{"InstanceType", "AutoScalingGroupName"}}, []string{"InstanceType=m5.large,AutoScalingGroupName=web"}},
+		{"empty set drops every dimension", [][]string{{}}, []string{""}},
+		{"missing dimension", [][]string{{"Service"}, {"AutoScalingGroupName", "Service"}}, nil},
+		{"configured twice", [][]string{{"AutoScalingGroupName"}, {"AutoScalingGroupName"}}, []string{"AutoScalingGroupName=web"}},
+		{"same set in another order", [][]string{{"InstanceType", "AutoScalingGroupName"}, {"AutoScalingGroupName", "InstanceType"}},
+			[]string{"InstanceType=m5.large,AutoScalingGroupName=web"}},
+		{"same as the datum", [][]string{{"InstanceType", "AutoScalingGroupName", "InstanceId"}}, nil},
+	}
+	for _, tt := range tests {
+		got := rollups(d, tt.sets)
+		if !reflect.DeepEqual(names(got), tt.want) {
+			t.Errorf("%s: rollups = %q, want %q", tt.name, names(got), tt.want)
+		}
+		for _, r := range got {
+			if aws.StringValue(r.MetricName) != "mem_used_percent" || aws.Float64Value(r.Value) != 50 {
+				t.Errorf("%s: rollup = %+v", tt.name, r)
+			}
+		}
+	}
+	if len(d.Dimensions) != 3 {
+		t.Errorf("the datum was changed: %v", d.Dimensions)
+	}
+}
+
+// gathered is a Gatherer returning fixed samples
+type gathered []Sample
+
+func (g gathered) Gather(context.Context) ([]Sample, error) { return g, nil }
+
+func TestCollectRollups(t *testing.T) {
+	sample := func(host string, v float64) Sample {
+		return Sample{Name: "mem_used_percent", Value: v, Dimensions: []Dimension{{Name: "host", Value: host}}}
+	}
+	s := scheduler{
+		collectors: []collector{{
+			key:      KeyMemory,
+			interval: time.Minute,
+			deltas:   NewDeltas(ModeRate, nil, time.Hour),
+			Gatherer: gathered{sample("a", 10), sample("b", 30)},
+		}},
+		publisher:  service.NewPublisher(service.CloudWatch{}, nil, service.Retry{}),
+		namespace:  "CoreOS",
+		dimensions: func() []Dimension { return []Dimension{{Name: "Service", Value: "web"}} },
+		rollups:    [][]string{{"Service"}, {}},
+	}
+	s.publisher.Aggregator = service.NewAggregator(0)
+	s.collect(context.Background(), 0, time.Date(2018, 11, 5, 10, 0, 0, 0, time.UTC))
+
+	_, data := s.publisher.Aggregator.Drain()
+	got := names(data["CoreOS"])
+	sort.Strings(got)
+	want := []string{"", "Service=web", "Service=web,host=a", "Service=web,host=b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %q, want %q", got, want)
+	}
+	// the hosts are merged into a single statistic set per rolled-up series
+	for _, d := range data["CoreOS"] {
+		if len(d.Dimensions) > 1 {
+			continue
+		}
+		if st := d.StatisticValues; st == nil || aws.Float64Value(st.SampleCount) != 2 || aws.Float64Value(st.Sum) != 40 {
+			t.Errorf("%q = %+v, want the two hosts merged", names([]service.Datum{d}), d)
+		}
+	}
+}
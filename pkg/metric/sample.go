@@ -0,0 +1,43 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"time"
+)
+
+// Unit of a sample, named after the cloud watch standard units
+type Unit string
+
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_MetricDatum.html
+const (
+	UnitNone         Unit = "None"
+	UnitPercent      Unit = "Percent"
+	UnitCount        Unit = "Count"
+	UnitBytes        Unit = "Bytes"
+	UnitSeconds      Unit = "Seconds"
+	UnitMilliseconds Unit = "Milliseconds"
+	UnitBytesSecond  Unit = "Bytes/Second"
+	UnitCountSecond  Unit = "Count/Second"
+)
+
+// Dimension is a name/value pair that describes a sample
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+// Sample is a single measurement returned by a Gatherer,
+// Counter marks values that only ever increase such as bytes sent since boot,
+// Timestamp, HighResolution and Distribution are left out by gatherers and set by the scheduler.
+// Distribution samples are aggregated into values and counts rather than a statistic set.
+type Sample struct {
+	Name           string
+	Value          float64
+	Unit           Unit
+	Dimensions     []Dimension
+	Counter        bool
+	Timestamp      time.Time
+	HighResolution bool
+	Distribution   bool
+}
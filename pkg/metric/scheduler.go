@@ -0,0 +1,151 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/slatunje/aws-cwa-metric/pkg/service"
+)
+
+// scheduler gathers samples from the chosen collectors and publishes them
+type scheduler struct {
+	collectors []collector
+	publisher  *service.Publisher
+	namespace  string
+	dimensions func() []Dimension
+	rollups    [][]string
+	align      bool
+	flush      time.Duration
+}
+
+// tick returns the greatest duration that divides the interval of every collector
+func (s scheduler) tick() time.Duration {
+	var gcd = func(a, b time.Duration) time.Duration {
+		for b != 0 {
+			a, b = b, a%b
+		}
+		return a
+	}
+	var d time.Duration
+	for _, c := range s.collectors {
+		d = gcd(d, c.interval)
+	}
+	if d <= 0 {
+		return interval()
+	}
+	return d
+}
+
+// collect metrics of the collectors due at tick n and hand them to the publisher,
+// every sample is timestamped at, a failing collector is logged and counted without stopping the others.
+// The rolled-up series of a collection are merged so each is published once per distinct dimension set.
+func (s scheduler) collect(ctx context.Context, n int64, at time.Time) {
+	var tick = s.tick()
+	var rolled = service.NewAggregator(0)
+	var dimensions = s.dimensions()
+	for _, c := range s.collectors {
+		if n%int64(c.interval/tick) != 0 {
+			continue
+		}
+		samples, err := c.Gather(ctx)
+		if err != nil {
+			log.Printf("collector %s failed: %s", c.key, err)
+		}
+		self.Collected(c.key, err)
+		samples = c.deltas.Apply(c.measure(samples), time.Now())
+		data := make([]service.Datum, 0, len(samples))
+		for _, sample := range samples {
+			if sample.Timestamp.IsZero() {
+				sample.Timestamp = at
+			}
+			d := NewDatum(sample, dimensions)
+			data = append(data, d)
+			rolled.Add(rollups(d, s.rollups), s.namespace)
+		}
+		s.publisher.Publish(data, s.namespace)
+	}
+	if _, data := rolled.Drain(); len(data[s.namespace]) > 0 {
+		s.publisher.Publish(data[s.namespace], s.namespace)
+	}
+}
+
+// flushes reports whether the publisher is flushed at tick n, which happens on every tick
+// unless a longer flush interval is set
+func (s scheduler) flushes(n int64, tick time.Duration) bool {
+	if s.flush <= tick {
+		return true
+	}
+	return n%int64(s.flush/tick) == 0
+}
+
+// OncePause is the time between the two collections of a single run, the first one only records the
+// baseline of the cpu percentages, counter rates and deltas and the diskio and procstat derived metrics
+var OncePause = 2 * time.Second
+
+// once collects all metrics a single time, after a baseline taken OncePause earlier
+func (s scheduler) once(ctx context.Context) {
+	s.baseline(ctx)
+	select {
+	case <-time.After(OncePause):
+	case <-ctx.Done():
+	}
+	s.collect(ctx, 0, s.now(s.tick()))
+	s.publisher.Flush()
+	s.publisher.Wait()
+}
+
+// baseline gathers every collector and drops the samples so the next collection has previous values
+func (s scheduler) baseline(ctx context.Context) {
+	for _, c := range s.collectors {
+		samples, _ := c.Gather(ctx)
+		c.deltas.Apply(c.measure(samples), time.Now())
+	}
+}
+
+// now returns the timestamp of a collection, truncated to the tick when aligned
+func (s scheduler) now(tick time.Duration) time.Time {
+	if s.align {
+		return time.Now().Truncate(tick).UTC()
+	}
+	return time.Now().UTC()
+}
+
+// forever will forever collect metrics unless interrupted,
+// when aligned collections start on a multiple of the tick (i.e. :00, :05)
+func (s scheduler) forever(ctx context.Context) {
+	var tick = s.tick()
+	if s.align {
+		select {
+		case <-time.After(time.Until(time.Now().Truncate(tick).Add(tick))):
+		case <-ctx.Done():
+		}
+	}
+	var tt = time.NewTicker(tick)
+	var n int64
+	{
+	loop:
+		for {
+			select {
+			case <-tt.C:
+				n++
+				at := s.now(tick)
+				if s.align {
+					n = at.UnixNano() / int64(tick)
+				}
+				s.collect(ctx, n, at)
+				if s.flushes(n, tick) {
+					s.publisher.Flush()
+				}
+			case <-ctx.Done():
+				log.Printf("ok stopping forever task due to: %s...", ctx.Err())
+				break loop
+			}
+		}
+	}
+	s.publisher.Flush()
+	s.publisher.Wait()
+	log.Println("shutdwon completed.")
+}
@@ -0,0 +1,171 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/host"
+)
+
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/metrics-collected-by-CloudWatch-agent.html
+const (
+	SystemLoad1            = "system_load1"
+	SystemLoad5            = "system_load5"
+	SystemLoad15           = "system_load15"
+	SystemLoad1PerCPU      = "system_load1_per_cpu"
+	SystemLoad5PerCPU      = "system_load5_per_cpu"
+	SystemLoad15PerCPU     = "system_load15_per_cpu"
+	SystemCPUs             = "system_n_cpus"
+	SystemUsers            = "system_n_users"
+	SystemUptime           = "system_uptime"
+	SystemProcessesTotal   = "system_processes_total"
+	SystemProcessesRunning = "system_processes_running"
+	SystemProcessesBlocked = "system_processes_blocked"
+	SystemProcessesZombies = "system_processes_zombies"
+	SystemContextSwitches  = "system_context_switches"
+	SystemForks            = "system_forks"
+)
+
+// ProcRoot is where the proc file system is mounted
+const ProcRoot = "/proc"
+
+// System metric entity, read from loadavg, stat and uptime below Proc
+type System struct {
+	Proc string
+}
+
+// Gather System load, raw and per cpu, uptime, users, processes per state, context switches and forks
+func (c System) Gather(ctx context.Context) (samples []Sample, err error) {
+	proc := c.Proc
+	if proc == "" {
+		proc = ProcRoot
+	}
+
+	var add = func(name string, value float64, unit Unit) {
+		samples = append(samples, Sample{Name: name, Value: value, Unit: unit})
+	}
+	var count = func(name string, value float64, unit Unit) {
+		samples = append(samples, Sample{Name: name, Value: value, Unit: unit, Counter: true})
+	}
+
+	stat, cpus, err := procStat(filepath.Join(proc, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	loads, err := readFields(filepath.Join(proc, "loadavg"), 3)
+	if err != nil {
+		return nil, err
+	}
+	uptime, err := readFields(filepath.Join(proc, "uptime"), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	add(SystemLoad1, loads[0], UnitNone)
+	add(SystemLoad5, loads[1], UnitNone)
+	add(SystemLoad15, loads[2], UnitNone)
+	if cpus > 0 {
+		add(SystemLoad1PerCPU, loads[0]/float64(cpus), UnitNone)
+		add(SystemLoad5PerCPU, loads[1]/float64(cpus), UnitNone)
+		add(SystemLoad15PerCPU, loads[2]/float64(cpus), UnitNone)
+	}
+	add(SystemCPUs, float64(cpus), UnitCount)
+	add(SystemUptime, uptime[0], UnitSeconds)
+
+	var errs Errors
+	// there is no utmp in most containers, nobody is logged in
+	if users, err := host.UsersWithContext(ctx); err == nil || os.IsNotExist(err) {
+		add(SystemUsers, float64(len(users)), UnitCount)
+	} else {
+		errs = append(errs, err)
+	}
+
+	total, zombies := procStates(proc)
+	add(SystemProcessesTotal, float64(total), UnitCount)
+	add(SystemProcessesRunning, stat["procs_running"], UnitCount)
+	add(SystemProcessesBlocked, stat["procs_blocked"], UnitCount)
+	add(SystemProcessesZombies, float64(zombies), UnitCount)
+	count(SystemContextSwitches, stat["ctxt"], UnitCount)
+	count(SystemForks, stat["processes"], UnitCount)
+
+	log.Printf("system - load:%v/%v/%v cpus:%d uptime:%v processes:%d\n", loads[0], loads[1], loads[2], cpus, uptime[0], total)
+
+	return samples, errs.Err()
+}
+
+// procStat returns the single value lines of a stat file (i.e. ctxt, processes, procs_running)
+// and the number of cpus it lists
+func procStat(path string) (values map[string]float64, cpus int, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	values = make(map[string]float64)
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if strings.HasPrefix(f[0], "cpu") && f[0] != "cpu" {
+			cpus++
+			continue
+		}
+		if len(f) == 2 {
+			if v, err := strconv.ParseFloat(f[1], 64); err == nil {
+				values[f[0]] = v
+			}
+		}
+	}
+	return values, cpus, nil
+}
+
+// readFields returns the first n numbers of a single line file (i.e. loadavg, uptime)
+func readFields(path string, n int) ([]float64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := strings.Fields(string(b))
+	if len(f) < n {
+		return nil, fmt.Errorf("%s: expected %d fields, found %d", path, n, len(f))
+	}
+	out := make([]float64, n)
+	for i := range out {
+		if out[i], err = strconv.ParseFloat(f[i], 64); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	return out, nil
+}
+
+// procStates returns the number of processes below proc and how many of them are zombies,
+// processes that exit while being read are left out
+func procStates(proc string) (total, zombies int) {
+	dirs, err := ioutil.ReadDir(proc)
+	if err != nil {
+		return
+	}
+	for _, d := range dirs {
+		if _, err := strconv.Atoi(d.Name()); err != nil || !d.IsDir() {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(proc, d.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		total++
+		// the state follows the command name, which is in parentheses and may contain spaces
+		if i := strings.LastIndexByte(string(b), ')'); i >= 0 && i+2 < len(b) && b[i+2] == 'Z' {
+			zombies++
+		}
+	}
+	return
+}
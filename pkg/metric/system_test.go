@@ -0,0 +1,120 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package metric
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testProc = filepath.Join("testdata", "proc")
+
+func TestSystemGather(t *testing.T) {
+	samples, err := System{Proc: testProc}.Gather(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := values(t, samples)
+	if _, ok := got[SystemUsers]; !ok {
+		t.Error("users left out")
+	}
+	delete(got, SystemUsers)
+	want := map[string]float64{
+		SystemLoad1:            1.5,
+		SystemLoad5:            3,
+		SystemLoad15:           4.2,
+		SystemLoad1PerCPU:      0.375,
+		SystemLoad5PerCPU:      0.75,
+		SystemLoad15PerCPU:     1.05,
+		SystemCPUs:             4,
+		SystemUptime:           350735.47,
+		SystemProcessesTotal:   3,
+		SystemProcessesRunning: 6,
+		SystemProcessesBlocked: 2,
+		SystemProcessesZombies: 1,
+		SystemContextSwitches:  115315,
+		SystemForks:            86031,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("samples = %v, want %v", got, want)
+	}
+	for _, s := range samples {
+		counter := s.Name == SystemContextSwitches || s.Name == SystemForks
+		if s.Counter != counter {
+			t.Errorf("%s: counter = %t, want %t", s.Name, s.Counter, counter)
+		}
+	}
+}
+
+func TestSystemGatherMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// each of stat, loadavg and uptime is required
+	files := map[string]string{"stat": "cpu0 1 2 3\nctxt 1\n", "loadavg": "0.1 0.2 0.3 1/2 3\n", "uptime": "12.5 3.0\n"}
+	for name := range files {
+		for other, content := range files {
+			os.Remove(filepath.Join(dir, other))
+			if other != name {
+				if err := ioutil.WriteFile(filepath.Join(dir, other), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if _, err := (System{Proc: dir}).Gather(context.Background()); err == nil {
+			t.Errorf("expected an error without %s", name)
+		}
+	}
+}
+
+func TestReadFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fields")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		content string
+		n       int
+		want    []float64
+		err     bool
+	}{
+		{"0.52 0.58 0.59 1/467 2818\n", 3, []float64{0.52, 0.58, 0.59}, false},
+		{"350735.47 234388.90\n", 1, []float64{350735.47}, false},
+		{"0.52 0.58\n", 3, nil, true},
+		{"", 1, nil, true},
+		{"0.52 high 0.59\n", 3, nil, true},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, "file")
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readFields(path, tt.n)
+		if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: fields = %v, %v, want %v", i, got, err, tt.want)
+		}
+	}
+	if _, err := readFields(filepath.Join(dir, "missing"), 1); !os.IsNotExist(err) {
+		t.Errorf("err = %v, want not exist", err)
+	}
+}
+
+func TestProcStates(t *testing.T) {
+	// the command name of 42 holds parentheses and a Z, self and 7 are not processes, 500 exited
+	total, zombies := procStates(testProc)
+	if total != 3 || zombies != 1 {
+		t.Errorf("processes = %d, zombies = %d, want 3 and 1", total, zombies)
+	}
+	if total, zombies := procStates(filepath.Join(testProc, "missing")); total != 0 || zombies != 0 {
+		t.Errorf("processes = %d, zombies = %d without proc", total, zombies)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+)
+
+// Aggregator merges the values of each series until it is drained, into a statistic set or, for data
+// that carries Values, into distinct values and their counts. A series is a namespace, metric name,
+// unit, storage resolution, set of dimensions and, with a Period, the period its timestamp falls in.
+type Aggregator struct {
+	Period time.Duration
+
+	mu     sync.Mutex
+	order  []string
+	series map[string]*series
+}
+
+// series is the aggregate of the values of a datum, timestamped at the start of its period
+// or, without period, at the time of the first value
+type series struct {
+	namespace string
+	datum     Datum
+	count     float64
+	sum       float64
+	min       float64
+	max       float64
+	counts    map[float64]float64
+}
+
+// NewAggregator returns an instance of `Aggregator` merging the values of each period,
+// a period of 0 merges all values until drained
+func NewAggregator(period time.Duration) *Aggregator {
+	return &Aggregator{Period: period, series: make(map[string]*series)}
+}
+
+// Add merges data into the series of namespace
+func (a *Aggregator) Add(data []Datum, namespace string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, d := range data {
+		var in series
+		switch {
+		case len(d.Values) > 0:
+			in.counts = make(map[float64]float64, len(d.Values))
+			for i, v := range d.Values {
+				n := 1.0
+				if i < len(d.Counts) {
+					n = d.Counts[i]
+				}
+				in.counts[v] += n
+			}
+		case d.StatisticValues != nil:
+			st := d.StatisticValues
+			in = series{
+				count: aws.Float64Value(st.SampleCount),
+				sum:   aws.Float64Value(st.Sum),
+				min:   aws.Float64Value(st.Minimum),
+				max:   aws.Float64Value(st.Maximum),
+			}
+		case d.Value != nil:
+			in = series{count: 1, sum: *d.Value, min: *d.Value, max: *d.Value}
+		default:
+			continue
+		}
+
+		key := seriesKey(namespace, d)
+		if a.Period > 0 && d.Timestamp != nil {
+			d.Timestamp = aws.Time(d.Timestamp.Truncate(a.Period))
+			key += "\x00" + strconv.FormatInt(d.Timestamp.UnixNano(), 10)
+		}
+		s, ok := a.series[key]
+		if !ok {
+			in.namespace, in.datum = namespace, d
+			a.order = append(a.order, key)
+			a.series[key] = &in
+			continue
+		}
+		if in.counts != nil {
+			if s.counts == nil {
+				s.counts = make(map[float64]float64)
+			}
+			for v, n := range in.counts {
+				s.counts[v] += n
+			}
+			continue
+		}
+		s.count += in.count
+		s.sum += in.sum
+		s.min = math.Min(s.min, in.min)
+		s.max = math.Max(s.max, in.max)
+	}
+}
+
+// Drain returns the datums of each series grouped by namespace and starts over. A series that
+// received a single value keeps it rather than a statistic set, a distribution is split into
+// datums of at most MaxValuesPerDatum values.
+func (a *Aggregator) Drain() (namespaces []string, data map[string][]Datum) {
+	a.mu.Lock()
+	order, all := a.order, a.series
+	a.order, a.series = nil, make(map[string]*series)
+	a.mu.Unlock()
+
+	data = make(map[string][]Datum)
+	for _, key := range order {
+		s := all[key]
+		if _, ok := data[s.namespace]; !ok {
+			namespaces = append(namespaces, s.namespace)
+		}
+		data[s.namespace] = append(data[s.namespace], s.drain()...)
+	}
+	return
+}
+
+// drain returns the datums of a series
+func (s *series) drain() (out []Datum) {
+	d := s.datum
+	d.Value, d.StatisticValues, d.Values, d.Counts = nil, nil, nil, nil
+
+	if s.counts != nil {
+		values := make([]float64, 0, len(s.counts))
+		for v := range s.counts {
+			values = append(values, v)
+		}
+		sort.Float64s(values)
+		for start := 0; start < len(values); start += MaxValuesPerDatum {
+			end := start + MaxValuesPerDatum
+			if end > len(values) {
+				end = len(values)
+			}
+			chunk := d
+			chunk.Values = values[start:end]
+			chunk.Counts = make([]float64, 0, end-start)
+			for _, v := range chunk.Values {
+				chunk.Counts = append(chunk.Counts, s.counts[v])
+			}
+			out = append(out, chunk)
+		}
+		return out
+	}
+
+	if s.count == 1 && s.sum == s.min {
+		d.Value = aws.Float64(s.sum)
+	} else {
+		d.StatisticValues = &cloudwatch.StatisticSet{
+			SampleCount: aws.Float64(s.count),
+			Sum:         aws.Float64(s.sum),
+			Minimum:     aws.Float64(s.min),
+			Maximum:     aws.Float64(s.max),
+		}
+	}
+	return []Datum{d}
+}
+
+// seriesKey identifies the series of a datum within namespace, a distribution is kept apart from
+// a statistic set of the same metric
+func seriesKey(namespace string, d Datum) string {
+	var b strings.Builder
+	b.WriteString(namespace)
+	b.WriteString("\x00" + aws.StringValue(d.MetricName))
+	b.WriteString("\x00" + string(d.Unit))
+	b.WriteString("\x00" + strconv.FormatInt(aws.Int64Value(d.StorageResolution), 10))
+	for _, dim := range d.Dimensions {
+		b.WriteString("\x00" + aws.StringValue(dim.Name) + "=" + aws.StringValue(dim.Value))
+	}
+	if len(d.Values) > 0 {
+		b.WriteString("\x00values")
+	}
+	return b.String()
+}
@@ -0,0 +1,190 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+)
+
+// value returns a datum of metric cpu on host a with a single value at
+func value(v float64, at time.Time) Datum {
+	return Datum{MetricDatum: cloudwatch.MetricDatum{
+		MetricName: aws.String("cpu"),
+		Dimensions: []cloudwatch.Dimension{{Name: aws.String("host"), Value: aws.String("a")}},
+		Timestamp:  aws.Time(at),
+		Value:      aws.Float64(v),
+	}}
+}
+
+// distribution returns a datum of metric latency with the given values and counts at
+func distribution(values, counts []float64, at time.Time) Datum {
+	return Datum{
+		MetricDatum: cloudwatch.MetricDatum{MetricName: aws.String("latency"), Timestamp: aws.Time(at)},
+		Values:      values,
+		Counts:      counts,
+	}
+}
+
+func TestAggregatorStatisticSet(t *testing.T) {
+	period := time.Minute
+	start := time.Date(2018, 11, 5, 10, 0, 0, 0, time.UTC)
+	set := func(count, sum, min, max float64) *cloudwatch.StatisticSet {
+		return &cloudwatch.StatisticSet{
+			SampleCount: aws.Float64(count), Sum: aws.Float64(sum),
+			Minimum: aws.Float64(min), Maximum: aws.Float64(max),
+		}
+	}
+	tests := []struct {
+		name  string
+		in    []Datum
+		value []float64
+		sets  []*cloudwatch.StatisticSet
+		at    []time.Time
+	}{
+		{
+			name:  "single value is kept",
+			in:    []Datum{value(5, start.Add(10*time.Second))},
+			value: []float64{5},
+			sets:  []*cloudwatch.StatisticSet{nil},
+			at:    []time.Time{start},
+		},
+		{
+			name:  "values of a period are merged",
+			in:    []Datum{value(5, start.Add(10*time.Second)), value(1, start.Add(20*time.Second)), value(9, start.Add(50*time.Second))},
+			value: []float64{0},
+			sets:  []*cloudwatch.StatisticSet{set(3, 15, 1, 9)},
+			at:    []time.Time{start},
+		},
+		{
+			name: "statistic sets are merged",
+			in: []Datum{
+				{MetricDatum: cloudwatch.MetricDatum{MetricName: aws.String("cpu"),
+					Dimensions: []cloudwatch.Dimension{{Name: aws.String("host"), Value: aws.String("a")}},
+					Timestamp:  aws.Time(start), StatisticValues: set(2, 10, 4, 6)}},
+				value(20, start.Add(30*time.Second)),
+			},
+			value: []float64{0},
+			sets:  []*cloudwatch.StatisticSet{set(3, 30, 4, 20)},
+			at:    []time.Time{start},
+		},
+		{
+			name:  "periods are kept apart",
+			in:    []Datum{value(5, start.Add(50*time.Second)), value(7, start.Add(70*time.Second))},
+			value: []float64{5, 7},
+			sets:  []*cloudwatch.StatisticSet{nil, nil},
+			at:    []time.Time{start, start.Add(period)},
+		},
+	}
+	for _, tt := range tests {
+		a := NewAggregator(period)
+		a.Add(tt.in, "ns")
+		namespaces, data := a.Drain()
+		if !reflect.DeepEqual(namespaces, []string{"ns"}) || len(data["ns"]) != len(tt.sets) {
+			t.Errorf("%s: drained %v %v", tt.name, namespaces, data)
+			continue
+		}
+		for i, d := range data["ns"] {
+			if !reflect.DeepEqual(d.StatisticValues, tt.sets[i]) {
+				t.Errorf("%s: statistic set = %v, want %v", tt.name, d.StatisticValues, tt.sets[i])
+			}
+			if tt.sets[i] == nil && aws.Float64Value(d.Value) != tt.value[i] {
+				t.Errorf("%s: value = %v, want %v", tt.name, aws.Float64Value(d.Value), tt.value[i])
+			}
+			if tt.sets[i] != nil && d.Value != nil {
+				t.Errorf("%s: value %v next to a statistic set", tt.name, *d.Value)
+			}
+			if !d.Timestamp.Equal(tt.at[i]) {
+				t.Errorf("%s: timestamp = %s, want the period boundary %s", tt.name, d.Timestamp, tt.at[i])
+			}
+			if aws.StringValue(d.Dimensions[0].Value) != "a" {
+				t.Errorf("%s: dimensions = %v", tt.name, d.Dimensions)
+			}
+		}
+	}
+}
+
+func TestAggregatorValuesCounts(t *testing.T) {
+	start := time.Date(2018, 11, 5, 10, 0, 0, 0, time.UTC)
+	// seq returns the numbers from first to last by step
+	seq := func(first, last, step float64) (out []float64) {
+		for v := first; v*step <= last*step; v += step {
+			out = append(out, v)
+		}
+		return
+	}
+	ones := func(n int) (out []float64) {
+		for i := 0; i < n; i++ {
+			out = append(out, 1)
+		}
+		return
+	}
+	tests := []struct {
+		name   string
+		in     []Datum
+		values [][]float64
+		counts [][]float64
+	}{
+		{
+			name:   "values are deduplicated and counted",
+			in:     []Datum{distribution([]float64{3}, []float64{1}, start), distribution([]float64{1}, []float64{1}, start), distribution([]float64{3}, []float64{1}, start)},
+			values: [][]float64{{1, 3}},
+			counts: [][]float64{{1, 2}},
+		},
+		{
+			name:   "counts default to 1 and add up",
+			in:     []Datum{distribution([]float64{2, 4}, nil, start), distribution([]float64{4}, []float64{5}, start)},
+			values: [][]float64{{2, 4}},
+			counts: [][]float64{{1, 6}},
+		},
+		{
+			name:   "a datum holds at most 150 values",
+			in:     []Datum{distribution(seq(MaxValuesPerDatum+1, 1, -1), nil, start)},
+			values: [][]float64{seq(1, MaxValuesPerDatum, 1), {MaxValuesPerDatum + 1}},
+			counts: [][]float64{ones(MaxValuesPerDatum), {1}},
+		},
+	}
+	for _, tt := range tests {
+		a := NewAggregator(time.Minute)
+		a.Add(tt.in, "ns")
+		_, data := a.Drain()
+		if len(data["ns"]) != len(tt.values) {
+			t.Errorf("%s: got %d datums, want %d", tt.name, len(data["ns"]), len(tt.values))
+			continue
+		}
+		for i, d := range data["ns"] {
+			if !reflect.DeepEqual(d.Values, tt.values[i]) || !reflect.DeepEqual(d.Counts, tt.counts[i]) {
+				t.Errorf("%s: values/counts = %v/%v, want %v/%v", tt.name, d.Values, d.Counts, tt.values[i], tt.counts[i])
+			}
+			if d.Value != nil || d.StatisticValues != nil {
+				t.Errorf("%s: a distribution has no value nor statistic set: %+v", tt.name, d)
+			}
+			if len(d.Values) > MaxValuesPerDatum {
+				t.Errorf("%s: %d values in a datum", tt.name, len(d.Values))
+			}
+		}
+	}
+}
+
+func TestAggregatorDrain(t *testing.T) {
+	a := NewAggregator(0)
+	start := time.Date(2018, 11, 5, 10, 0, 30, 0, time.UTC)
+	a.Add([]Datum{value(1, start)}, "first")
+	a.Add([]Datum{value(2, start), value(3, start.Add(time.Minute))}, "second")
+
+	namespaces, data := a.Drain()
+	if !reflect.DeepEqual(namespaces, []string{"first", "second"}) {
+		t.Errorf("namespaces = %v", namespaces)
+	}
+	// without period the values are merged whatever their time, at the time of the first one
+	if d := data["second"]; len(d) != 1 || aws.Float64Value(d[0].StatisticValues.Sum) != 5 || !d[0].Timestamp.Equal(start) {
+		t.Errorf("second = %+v", d)
+	}
+	if namespaces, _ := a.Drain(); len(namespaces) != 0 {
+		t.Errorf("drained %v twice", namespaces)
+	}
+}
@@ -3,31 +3,82 @@
 package service
 
 import (
-	"log"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/private/protocol"
+	"github.com/aws/aws-sdk-go-v2/private/protocol/query"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 )
 
-// CloudWatch stores an aws configuration
+// MaxValuesPerDatum is the number of distinct values a datum may carry
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_MetricDatum.html
+const MaxValuesPerDatum = 150
+
+// Datum is a metric datum that may also hold the distinct Values of a distribution and how many
+// times each was seen, which PutMetricData accepts in place of a value or a statistic set
+// but the sdk does not model
+type Datum struct {
+	cloudwatch.MetricDatum
+	Values []float64 `json:",omitempty"`
+	Counts []float64 `json:",omitempty"`
+}
+
+// CloudWatch stores an aws configuration and a reusable client
 type CloudWatch struct {
 	Config aws.Config
+	Client *cloudwatch.CloudWatch
 }
 
-// NewCloudWatch creates and instance of service.CloudWatch
+// NewCloudWatch creates and instance of service.CloudWatch,
+// retries are left to the `Retry` policy of the publisher rather than the sdk
 func NewCloudWatch(cfg aws.Config) CloudWatch {
-	return CloudWatch{Config: cfg}
+	cfg.Retryer = aws.DefaultRetryer{NumMaxRetries: 0}
+	return CloudWatch{Config: cfg, Client: cloudwatch.New(cfg)}
 }
 
 // Publish saves metric data to cloud watch using AWS CloudWatch API
-func (c CloudWatch) Publish(data []cloudwatch.MetricDatum, namespace string) {
-	svc := cloudwatch.New(c.Config)
-	req := svc.PutMetricDataRequest(&cloudwatch.PutMetricDataInput{
-		MetricData: data,
-		Namespace:  &namespace,
-	})
-	_, err := req.Send()
-	if err != nil {
-		log.Fatal(err)
+func (c CloudWatch) Publish(data []Datum, namespace string) error {
+	input := &putMetricDataInput{Namespace: aws.String(namespace)}
+	for _, d := range data {
+		input.MetricData = append(input.MetricData, metricDatum{
+			Counts:            d.Counts,
+			Dimensions:        d.Dimensions,
+			MetricName:        d.MetricName,
+			StatisticValues:   d.StatisticValues,
+			StorageResolution: d.StorageResolution,
+			Timestamp:         d.Timestamp,
+			Unit:              d.Unit,
+			Value:             d.Value,
+			Values:            d.Values,
+		})
 	}
+	req := c.Client.NewRequest(&aws.Operation{Name: "PutMetricData", HTTPMethod: "POST", HTTPPath: "/"},
+		input, &cloudwatch.PutMetricDataOutput{})
+	req.Handlers.Unmarshal.Remove(query.UnmarshalHandler)
+	req.Handlers.Unmarshal.PushBackNamed(protocol.UnmarshalDiscardBodyHandler)
+	return req.Send()
+}
+
+// putMetricDataInput is cloudwatch.PutMetricDataInput with the Values and Counts of a datum
+type putMetricDataInput struct {
+	_ struct{} `type:"structure"`
+
+	MetricData []metricDatum `type:"list" required:"true"`
+	Namespace  *string       `min:"1" type:"string" required:"true"`
+}
+
+// metricDatum is the wire form of a Datum
+type metricDatum struct {
+	_ struct{} `type:"structure"`
+
+	Counts            []float64                `type:"list"`
+	Dimensions        []cloudwatch.Dimension   `type:"list"`
+	MetricName        *string                  `min:"1" type:"string" required:"true"`
+	StatisticValues   *cloudwatch.StatisticSet `type:"structure"`
+	StorageResolution *int64                   `min:"1" type:"integer"`
+	Timestamp         *time.Time               `type:"timestamp" timestampFormat:"iso8601"`
+	Unit              cloudwatch.StandardUnit  `type:"string" enum:"true"`
+	Value             *float64                 `type:"double"`
+	Values            []float64                `type:"list"`
 }
@@ -0,0 +1,107 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+)
+
+// newTestCloudWatch returns a CloudWatch sending to a stand-in that records the form of each request
+func newTestCloudWatch(status int) (CloudWatch, *[]url.Values, func()) {
+	var forms []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.PostForm)
+		w.WriteHeader(status)
+		w.Write([]byte(putMetricDataResponse))
+	}))
+	return testCloudWatch(srv.URL), &forms, srv.Close
+}
+
+// putMetricDataResponse is the body of a successful PutMetricData
+const putMetricDataResponse = `<PutMetricDataResponse><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></PutMetricDataResponse>`
+
+// testCloudWatch returns a CloudWatch sending to the stand-in at endpoint
+func testCloudWatch(endpoint string) CloudWatch {
+	cfg := defaults.Config()
+	cfg.Region = "eu-west-1"
+	cfg.Credentials = aws.NewStaticCredentialsProvider("AKID", "SECRET", "")
+	cfg.EndpointResolver = aws.ResolveWithEndpointURL(endpoint)
+	return NewCloudWatch(cfg)
+}
+
+func TestCloudWatchPublish(t *testing.T) {
+	cw, forms, done := newTestCloudWatch(http.StatusOK)
+	defer done()
+
+	at := time.Date(2018, 11, 5, 10, 0, 0, 0, time.UTC)
+	err := cw.Publish([]Datum{
+		value(5, at),
+		distribution([]float64{1.5, 3}, []float64{2, 1}, at),
+	}, "CoreOS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*forms) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(*forms))
+	}
+	form := (*forms)[0]
+	want := map[string]string{
+		"Action":                                        "PutMetricData",
+		"Namespace":                                     "CoreOS",
+		"MetricData.member.1.MetricName":                "cpu",
+		"MetricData.member.1.Value":                     "5",
+		"MetricData.member.1.Timestamp":                 "2018-11-05T10:00:00Z",
+		"MetricData.member.1.Dimensions.member.1.Name":  "host",
+		"MetricData.member.1.Dimensions.member.1.Value": "a",
+		"MetricData.member.2.MetricName":                "latency",
+		"MetricData.member.2.Values.member.1":           "1.5",
+		"MetricData.member.2.Values.member.2":           "3",
+		"MetricData.member.2.Counts.member.1":           "2",
+		"MetricData.member.2.Counts.member.2":           "1",
+	}
+	for k, v := range want {
+		if got := form.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	for _, k := range []string{"MetricData.member.1.Values.member.1", "MetricData.member.2.Value"} {
+		if _, ok := form[k]; ok {
+			t.Errorf("unexpected %s", k)
+		}
+	}
+
+	// datumSize accounts for every parameter of the request
+	var size int
+	for i, d := range []Datum{value(5, at), distribution([]float64{1.5, 3}, []float64{2, 1}, at)} {
+		size += datumSize(i+1, d)
+	}
+	var sent int
+	for k, vs := range form {
+		if strings.HasPrefix(k, "MetricData.") {
+			sent += len(k) + len(url.QueryEscape(vs[0])) + 2
+		}
+	}
+	if size < sent {
+		t.Errorf("datum size = %d, below the %d bytes sent", size, sent)
+	}
+}
+
+func TestCloudWatchPublishError(t *testing.T) {
+	cw, _, done := newTestCloudWatch(http.StatusBadRequest)
+	defer done()
+
+	d := cloudwatch.MetricDatum{MetricName: aws.String("cpu"), Value: aws.Float64(1)}
+	if err := cw.Publish([]Datum{{MetricDatum: d}}, "CoreOS"); err == nil {
+		t.Error("expected an error for a rejected request")
+	}
+}
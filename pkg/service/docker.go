@@ -0,0 +1,235 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// https://docs.docker.com/engine/api/v1.25/
+const (
+	DockerSocket  = "/var/run/docker.sock"
+	DockerTimeout = 10 * time.Second
+)
+
+// dockerHostEnv overrides the address of the daemon (i.e. unix:///tmp/docker.sock or tcp://127.0.0.1:2375)
+const dockerHostEnv = "DOCKER_HOST"
+
+// DockerEngine talks to the Docker Engine API, over a unix socket by default
+type DockerEngine struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+// NewDockerEngine returns an instance of `DockerEngine` for the daemon listening on DOCKER_HOST,
+// or on socket when it is not set
+func NewDockerEngine(socket string) (*DockerEngine, error) {
+	host := os.Getenv(dockerHostEnv)
+	if host == "" {
+		host = "unix://" + socket
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		dialer := &net.Dialer{}
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}
+		return &DockerEngine{Endpoint: "http://docker", Client: &http.Client{Transport: transport, Timeout: DockerTimeout}}, nil
+	case "tcp", "http":
+		return &DockerEngine{Endpoint: "http://" + u.Host, Client: &http.Client{Timeout: DockerTimeout}}, nil
+	}
+	return nil, fmt.Errorf("unsupported docker host %q", host)
+}
+
+// DockerContainer is an entry of the container list
+type DockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// Name returns the name of the container without its leading slash
+func (c DockerContainer) Name() string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// DockerStats is a single read of the stats of a container
+type DockerStats struct {
+	Read        time.Time                     `json:"read"`
+	CPUStats    DockerCPUStats                `json:"cpu_stats"`
+	PreCPUStats DockerCPUStats                `json:"precpu_stats"`
+	MemoryStats DockerMemoryStats             `json:"memory_stats"`
+	Networks    map[string]DockerNetworkStats `json:"networks"`
+	BlkioStats  DockerBlkioStats              `json:"blkio_stats"`
+	PidsStats   DockerPidsStats               `json:"pids_stats"`
+}
+
+// DockerCPUStats holds the cpu time of a container and of the host in nanoseconds
+type DockerCPUStats struct {
+	CPUUsage struct {
+		TotalUsage        uint64   `json:"total_usage"`
+		PercpuUsage       []uint64 `json:"percpu_usage"`
+		UsageInKernelmode uint64   `json:"usage_in_kernelmode"`
+		UsageInUsermode   uint64   `json:"usage_in_usermode"`
+	} `json:"cpu_usage"`
+	SystemUsage uint64 `json:"system_cpu_usage"`
+	OnlineCPUs  uint64 `json:"online_cpus"`
+}
+
+// DockerMemoryStats holds the memory usage of a container, Stats follows memory.stat
+type DockerMemoryStats struct {
+	Usage uint64            `json:"usage"`
+	Limit uint64            `json:"limit"`
+	Stats map[string]uint64 `json:"stats"`
+}
+
+// DockerNetworkStats holds the counters of an interface of a container
+type DockerNetworkStats struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// DockerPidsStats holds the number of processes of a container
+type DockerPidsStats struct {
+	Current uint64 `json:"current"`
+}
+
+// DockerBlkioStats holds the block io counters of a container per device and operation
+type DockerBlkioStats struct {
+	IoServiceBytesRecursive []DockerBlkioEntry `json:"io_service_bytes_recursive"`
+	IoServicedRecursive     []DockerBlkioEntry `json:"io_serviced_recursive"`
+}
+
+// DockerBlkioEntry is a counter of a device and operation (i.e. Read, Write)
+type DockerBlkioEntry struct {
+	Major uint64 `json:"major"`
+	Minor uint64 `json:"minor"`
+	Op    string `json:"op"`
+	Value uint64 `json:"value"`
+}
+
+// DockerInspect is the part of the low level information of a container about its state
+type DockerInspect struct {
+	ID           string `json:"Id"`
+	RestartCount int    `json:"RestartCount"`
+	State        struct {
+		Status    string    `json:"Status"`
+		Running   bool      `json:"Running"`
+		Paused    bool      `json:"Paused"`
+		OOMKilled bool      `json:"OOMKilled"`
+		ExitCode  int       `json:"ExitCode"`
+		StartedAt time.Time `json:"StartedAt"`
+	} `json:"State"`
+}
+
+// DockerEvent is an event of the daemon, Attributes of a container event hold its name, image and labels
+type DockerEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+// Containers returns the running containers, or all of them including stopped ones
+func (d *DockerEngine) Containers(ctx context.Context, all bool) (containers []DockerContainer, err error) {
+	path := "/containers/json"
+	if all {
+		path += "?all=1"
+	}
+	err = d.get(ctx, path, &containers)
+	return
+}
+
+// Inspect returns the state of a container
+func (d *DockerEngine) Inspect(ctx context.Context, id string) (inspect DockerInspect, err error) {
+	err = d.get(ctx, "/containers/"+url.PathEscape(id)+"/json", &inspect)
+	return
+}
+
+// Events returns the container events between since and until, the daemon ends the stream at until
+func (d *DockerEngine) Events(ctx context.Context, since, until time.Time) (events []DockerEvent, err error) {
+	filters, _ := json.Marshal(map[string][]string{"type": {"container"}})
+	query := url.Values{
+		"since":   {fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())},
+		"until":   {fmt.Sprintf("%d.%09d", until.Unix(), until.Nanosecond())},
+		"filters": {string(filters)},
+	}
+	res, err := d.do(ctx, "/events?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	dec := json.NewDecoder(res.Body)
+	for dec.More() {
+		var e DockerEvent
+		if err := dec.Decode(&e); err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Stats returns a single read of the stats of a container, which includes the previous cpu read
+func (d *DockerEngine) Stats(ctx context.Context, id string) (stats DockerStats, err error) {
+	err = d.get(ctx, "/containers/"+url.PathEscape(id)+"/stats?stream=false", &stats)
+	return
+}
+
+// get sends a GET request to the daemon and decodes the json response into v
+func (d *DockerEngine) get(ctx context.Context, path string, v interface{}) error {
+	res, err := d.do(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// do sends a GET request to the daemon, a response other than 200 is returned as an error
+func (d *DockerEngine) do(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, d.Endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := d.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return nil, fmt.Errorf("docker %s: %d %s", strings.SplitN(path, "?", 2)[0], res.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return res, nil
+}
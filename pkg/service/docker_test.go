@@ -0,0 +1,224 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// dockerd is a fake Docker daemon serving canned json responses by path on a unix socket
+type dockerd struct {
+	socket    string
+	responses map[string]string
+	requests  []*http.Request
+	close     func()
+}
+
+func newDockerd(t *testing.T, responses map[string]string) *dockerd {
+	dir, err := ioutil.TempDir("", "dockerd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &dockerd{socket: filepath.Join(dir, "docker.sock"), responses: responses}
+	l, err := net.Listen("unix", d.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: d}
+	go srv.Serve(l)
+	d.close = func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+	return d
+}
+
+func (d *dockerd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.requests = append(d.requests, r)
+	body, ok := d.responses[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"No such container"}`))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(body))
+}
+
+func TestNewDockerEngine(t *testing.T) {
+	defer os.Unsetenv(dockerHostEnv)
+
+	os.Setenv(dockerHostEnv, "tcp://127.0.0.1:2375")
+	d, err := NewDockerEngine(DockerSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Endpoint != "http://127.0.0.1:2375" {
+		t.Errorf("endpoint = %s", d.Endpoint)
+	}
+
+	os.Setenv(dockerHostEnv, "ssh://docker@host")
+	if _, err := NewDockerEngine(DockerSocket); err == nil {
+		t.Error("expected an error for an ssh docker host")
+	}
+}
+
+func TestDockerEngineContainers(t *testing.T) {
+	daemon := newDockerd(t, map[string]string{
+		"/containers/json": `[
+			{"Id":"3f2a9c1d5e7b","Names":["/web"],"Image":"nginx:1.15","State":"running","Labels":{"com.docker.compose.service":"web"}},
+			{"Id":"9b8c7d6e5f4a","Names":[],"Image":"redis:5","State":"exited"}
+		]`,
+	})
+	defer daemon.close()
+
+	// the socket is used when DOCKER_HOST is not set
+	os.Unsetenv(dockerHostEnv)
+	d, err := NewDockerEngine(daemon.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containers, err := d.Containers(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 2 {
+		t.Fatalf("got %d containers, want 2", len(containers))
+	}
+	if got := daemon.requests[0].URL.Query().Get("all"); got != "1" {
+		t.Errorf("all = %q, want 1", got)
+	}
+	if c := containers[0]; c.Name() != "web" || c.Image != "nginx:1.15" || c.Labels["com.docker.compose.service"] != "web" {
+		t.Errorf("container = %+v", c)
+	}
+	if c := containers[1]; c.Name() != "9b8c7d6e5f4a" || c.State != "exited" {
+		t.Errorf("a container without name should be named after its id: %+v", c)
+	}
+
+	// DOCKER_HOST takes precedence over the socket
+	os.Setenv(dockerHostEnv, "unix://"+daemon.socket)
+	defer os.Unsetenv(dockerHostEnv)
+	d, err = NewDockerEngine("/nonexistent/docker.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Containers(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := daemon.requests[1].URL.RawQuery; got != "" {
+		t.Errorf("query = %q, want none for running containers", got)
+	}
+}
+
+func TestDockerEngineStats(t *testing.T) {
+	daemon := newDockerd(t, map[string]string{
+		"/containers/3f2a9c1d5e7b/stats": `{
+			"read":"2018-11-05T10:00:00Z",
+			"cpu_stats":{"cpu_usage":{"total_usage":3000000000,"usage_in_kernelmode":1000000000,"usage_in_usermode":2000000000},"system_cpu_usage":20000000000,"online_cpus":2},
+			"precpu_stats":{"cpu_usage":{"total_usage":2000000000},"system_cpu_usage":10000000000,"online_cpus":2},
+			"memory_stats":{"usage":104857600,"limit":536870912,"stats":{"total_inactive_file":4857600}},
+			"networks":{"eth0":{"rx_bytes":1024,"tx_bytes":2048}},
+			"blkio_stats":{"io_service_bytes_recursive":[{"major":8,"minor":0,"op":"Read","value":4096}]},
+			"pids_stats":{"current":7}
+		}`,
+	})
+	defer daemon.close()
+
+	os.Unsetenv(dockerHostEnv)
+	d, err := NewDockerEngine(daemon.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := d.Stats(context.Background(), "3f2a9c1d5e7b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := daemon.requests[0].URL.Query().Get("stream"); got != "false" {
+		t.Errorf("stream = %q, want false", got)
+	}
+	if stats.CPUStats.CPUUsage.TotalUsage != 3000000000 || stats.PreCPUStats.SystemUsage != 10000000000 {
+		t.Errorf("cpu stats = %+v / %+v", stats.CPUStats, stats.PreCPUStats)
+	}
+	if stats.MemoryStats.Limit != 536870912 || stats.MemoryStats.Stats["total_inactive_file"] != 4857600 {
+		t.Errorf("memory stats = %+v", stats.MemoryStats)
+	}
+	if stats.Networks["eth0"].TxBytes != 2048 || stats.PidsStats.Current != 7 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if b := stats.BlkioStats.IoServiceBytesRecursive; len(b) != 1 || b[0].Op != "Read" || b[0].Value != 4096 {
+		t.Errorf("blkio = %+v", b)
+	}
+
+	if _, err := d.Stats(context.Background(), "missing"); err == nil {
+		t.Error("expected an error for an unknown container")
+	}
+}
+
+func TestDockerEngineInspect(t *testing.T) {
+	daemon := newDockerd(t, map[string]string{
+		"/containers/3f2a9c1d5e7b/json": `{
+			"Id":"3f2a9c1d5e7b","RestartCount":3,
+			"State":{"Status":"exited","Running":false,"OOMKilled":true,"ExitCode":137,"StartedAt":"2018-11-05T10:00:00.123456789Z"}
+		}`,
+	})
+	defer daemon.close()
+
+	os.Unsetenv(dockerHostEnv)
+	d, err := NewDockerEngine(daemon.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inspect, err := d.Inspect(context.Background(), "3f2a9c1d5e7b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inspect.RestartCount != 3 || !inspect.State.OOMKilled || inspect.State.ExitCode != 137 || inspect.State.Running {
+		t.Errorf("inspect = %+v", inspect)
+	}
+	if inspect.State.StartedAt.Nanosecond() != 123456789 {
+		t.Errorf("started at = %s", inspect.State.StartedAt)
+	}
+}
+
+func TestDockerEngineEvents(t *testing.T) {
+	daemon := newDockerd(t, map[string]string{
+		"/events": `{"Type":"container","Action":"die","Actor":{"ID":"3f2a9c1d5e7b","Attributes":{"name":"web","image":"nginx:1.15","exitCode":"137"}},"timeNano":1541412000000000000}
+{"Type":"container","Action":"restart","Actor":{"ID":"3f2a9c1d5e7b","Attributes":{"name":"web","image":"nginx:1.15"}},"timeNano":1541412001000000000}
+`,
+	})
+	defer daemon.close()
+
+	os.Unsetenv(dockerHostEnv)
+	d, err := NewDockerEngine(daemon.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := time.Date(2018, 11, 5, 10, 0, 0, 5, time.UTC)
+	events, err := d.Events(context.Background(), since, since.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if e := events[0]; e.Action != "die" || e.Actor.ID != "3f2a9c1d5e7b" || e.Actor.Attributes["exitCode"] != "137" {
+		t.Errorf("event = %+v", e)
+	}
+	query := daemon.requests[0].URL.Query()
+	if got := query.Get("since"); got != "1541412000.000000005" {
+		t.Errorf("since = %q", got)
+	}
+	if got := query.Get("until"); got != "1541412060.000000005" {
+		t.Errorf("until = %q", got)
+	}
+	if got := query.Get("filters"); got != `{"type":["container"]}` {
+		t.Errorf("filters = %q", got)
+	}
+}
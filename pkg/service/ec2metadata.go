@@ -3,32 +3,175 @@
 package service
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/ec2metadata"
 )
 
-// EC2MetaData retrieve ec2 metadata
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/configuring-instance-metadata-service.html
+const (
+	MetaDataEndpoint = "http://169.254.169.254"
+	MetaDataTokenTTL = 6 * time.Hour
+	MetaDataTimeout  = 2 * time.Second
+)
+
+const (
+	metaDataTokenHeader    = "X-aws-ec2-metadata-token"
+	metaDataTokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	metaDataEndpointEnv    = "AWS_EC2_METADATA_SERVICE_ENDPOINT"
+)
+
+// EC2MetaData retrieve ec2 metadata, using an IMDSv2 session token and falling back to
+// IMDSv1 only when AllowV1 is set
 type EC2MetaData struct {
-	Config aws.Config
-	Meta   *ec2metadata.EC2Metadata
+	Config   aws.Config
+	Endpoint string
+	TokenTTL time.Duration
+	AllowV1  bool
+	Client   *http.Client
+
+	session *session
+}
+
+// session is the IMDSv2 token shared by all copies of an `EC2MetaData`
+type session struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
 }
 
 // NewEC2MetaData returns an instance of `EC2MetaData`
 func NewEC2MetaData(cfg aws.Config) EC2MetaData {
-	return EC2MetaData{Config: cfg, Meta: ec2metadata.New(cfg)}
+	endpoint := MetaDataEndpoint
+	if v := os.Getenv(metaDataEndpointEnv); v != "" {
+		endpoint = v
+	}
+	return EC2MetaData{
+		Config:   cfg,
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		TokenTTL: MetaDataTokenTTL,
+		Client:   &http.Client{Timeout: MetaDataTimeout},
+		session:  &session{},
+	}
 }
 
 // ID return the instance id from meta data
 func (e *EC2MetaData) ID() (string, error) {
-	return e.Meta.GetMetadata("instance-id")
+	return e.GetMetadata("instance-id")
 }
 
 // UserData returns user data used in to boot current instance
 func (e *EC2MetaData) UserData() (string, error) {
-	return e.Meta.GetUserData()
+	return e.get("/latest/user-data")
+}
+
+// GetMetadata returns the meta data found at path (i.e. placement/availability-zone)
+func (e *EC2MetaData) GetMetadata(path string) (string, error) {
+	return e.get("/latest/meta-data/" + strings.TrimPrefix(path, "/"))
 }
 
 // IDDoc returns an `ec2metadata.EC2InstanceIdentityDocument` object
-func (e *EC2MetaData) IDDoc() (ec2metadata.EC2InstanceIdentityDocument, error) {
-	return e.Meta.GetInstanceIdentityDocument()
+func (e *EC2MetaData) IDDoc() (doc ec2metadata.EC2InstanceIdentityDocument, err error) {
+	out, err := e.get("/latest/dynamic/instance-identity/document")
+	if err != nil {
+		return doc, err
+	}
+	err = json.Unmarshal([]byte(out), &doc)
+	return doc, err
+}
+
+// get sends a GET request with the session token, a rejected token is renewed once
+func (e *EC2MetaData) get(path string) (string, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := e.token()
+		if err != nil {
+			return "", err
+		}
+		req, err := http.NewRequest(http.MethodGet, e.Endpoint+path, nil)
+		if err != nil {
+			return "", err
+		}
+		if token != "" {
+			req.Header.Set(metaDataTokenHeader, token)
+		}
+		status, body, err := e.do(req)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case status == http.StatusUnauthorized && attempt == 0:
+			e.expire()
+			continue
+		case status != http.StatusOK:
+			return "", fmt.Errorf("ec2 metadata %s: %d %s", path, status, http.StatusText(status))
+		}
+		return body, nil
+	}
+}
+
+// token returns the current session token and requests a new one shortly before it expires,
+// an empty token means IMDSv1 is used
+func (e *EC2MetaData) token() (string, error) {
+	e.session.mu.Lock()
+	defer e.session.mu.Unlock()
+
+	if e.session.token != "" && time.Now().Before(e.session.expires) {
+		return e.session.token, nil
+	}
+
+	ttl := e.TokenTTL
+	if ttl < time.Second {
+		ttl = MetaDataTokenTTL
+	}
+	req, err := http.NewRequest(http.MethodPut, e.Endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(metaDataTokenTTLHeader, strconv.Itoa(int(ttl/time.Second)))
+
+	status, body, err := e.do(req)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("ec2 metadata token: %d %s", status, http.StatusText(status))
+	}
+	if err != nil {
+		if e.AllowV1 {
+			return "", nil
+		}
+		return "", err
+	}
+
+	// refresh once 90% of the time to live has elapsed
+	e.session.token = strings.TrimSpace(body)
+	e.session.expires = time.Now().Add(ttl - ttl/10)
+	return e.session.token, nil
+}
+
+// expire drops the current session token
+func (e *EC2MetaData) expire() {
+	e.session.mu.Lock()
+	e.session.token = ""
+	e.session.mu.Unlock()
+}
+
+// do sends req and returns the status code and body of the response
+func (e *EC2MetaData) do(req *http.Request) (int, string, error) {
+	res, err := e.Client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	return res.StatusCode, string(b), nil
 }
@@ -0,0 +1,190 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// imds is an httptest stand-in of the instance metadata service
+type imds struct {
+	mu      sync.Mutex
+	v1Only  bool              // reject token requests, as an IMDSv1 only service or a hop limit too low would
+	v2Only  bool              // reject requests without a token
+	revoke  bool              // reject the next request that carries a token with 401
+	issued  int               // number of tokens issued
+	ttl     string            // ttl header of the last token request
+	meta    map[string]string // meta data by path (i.e. instance-id)
+	tokens  map[string]bool
+	paths   []string
+	headers []string
+}
+
+func (m *imds) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r.URL.Path == "/latest/api/token" {
+		if r.Method != http.MethodPut || m.v1Only {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		m.issued++
+		m.ttl = r.Header.Get(metaDataTokenTTLHeader)
+		token := "token-" + strconv.Itoa(m.issued)
+		if m.tokens == nil {
+			m.tokens = make(map[string]bool)
+		}
+		m.tokens[token] = true
+		w.Write([]byte(token))
+		return
+	}
+
+	token := r.Header.Get(metaDataTokenHeader)
+	m.paths = append(m.paths, r.URL.Path)
+	m.headers = append(m.headers, token)
+	switch {
+	case token == "" && m.v2Only:
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	case token != "" && m.revoke:
+		m.revoke = false
+		delete(m.tokens, token)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	case token != "" && !m.tokens[token]:
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if v, ok := m.meta[strings.TrimPrefix(r.URL.Path, "/latest/meta-data/")]; ok {
+		w.Write([]byte(v))
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func newTestMetaData(m *imds) (EC2MetaData, func()) {
+	srv := httptest.NewServer(m)
+	e := NewEC2MetaData(aws.Config{})
+	e.Endpoint = srv.URL
+	if m.meta == nil {
+		m.meta = make(map[string]string)
+	}
+	m.meta["instance-id"] = "i-0123456789abcdef0"
+	return e, srv.Close
+}
+
+func TestEC2MetaDataToken(t *testing.T) {
+	m := &imds{v2Only: true}
+	e, done := newTestMetaData(m)
+	defer done()
+	e.TokenTTL = 10 * time.Minute
+
+	for i := 0; i < 3; i++ {
+		id, err := e.ID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != "i-0123456789abcdef0" {
+			t.Errorf("id = %q", id)
+		}
+	}
+	if m.issued != 1 {
+		t.Errorf("issued %d tokens, want 1 reused by every request", m.issued)
+	}
+	if m.ttl != "600" {
+		t.Errorf("ttl header = %q, want 600", m.ttl)
+	}
+	for _, h := range m.headers {
+		if h != "token-1" {
+			t.Errorf("token header = %q, want token-1", h)
+		}
+	}
+}
+
+func TestEC2MetaDataTokenRefresh(t *testing.T) {
+	m := &imds{v2Only: true}
+	e, done := newTestMetaData(m)
+	defer done()
+	e.TokenTTL = time.Hour
+
+	before := time.Now()
+	if _, err := e.ID(); err != nil {
+		t.Fatal(err)
+	}
+	expires := e.session.expires.Sub(before)
+	if expires < 53*time.Minute || expires > 55*time.Minute {
+		t.Errorf("token refreshed after %s, want 90%% of the 1h ttl", expires)
+	}
+
+	// once 90% of the ttl has elapsed a new token is requested
+	e.session.mu.Lock()
+	e.session.expires = time.Now().Add(-time.Second)
+	e.session.mu.Unlock()
+	if _, err := e.ID(); err != nil {
+		t.Fatal(err)
+	}
+	if m.issued != 2 {
+		t.Errorf("issued %d tokens, want 2", m.issued)
+	}
+}
+
+func TestEC2MetaDataRetoken(t *testing.T) {
+	m := &imds{v2Only: true}
+	e, done := newTestMetaData(m)
+	defer done()
+
+	if _, err := e.ID(); err != nil {
+		t.Fatal(err)
+	}
+	m.revoke = true
+	id, err := e.ID()
+	if err != nil {
+		t.Fatalf("a 401 should request a new token: %s", err)
+	}
+	if id != "i-0123456789abcdef0" {
+		t.Errorf("id = %q", id)
+	}
+	if m.issued != 2 {
+		t.Errorf("issued %d tokens, want 2", m.issued)
+	}
+}
+
+func TestEC2MetaDataFallback(t *testing.T) {
+	m := &imds{v1Only: true}
+	e, done := newTestMetaData(m)
+	defer done()
+	e.AllowV1 = true
+
+	id, err := e.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "i-0123456789abcdef0" {
+		t.Errorf("id = %q", id)
+	}
+	if len(m.headers) != 1 || m.headers[0] != "" {
+		t.Errorf("token headers = %q, want a single IMDSv1 request", m.headers)
+	}
+}
+
+func TestEC2MetaDataNoFallback(t *testing.T) {
+	m := &imds{v1Only: true}
+	e, done := newTestMetaData(m)
+	defer done()
+
+	if _, err := e.ID(); err == nil {
+		t.Fatal("expected an error when the token is refused and v1 is not allowed")
+	}
+	if len(m.paths) != 0 {
+		t.Errorf("requested %q without a token", m.paths)
+	}
+}
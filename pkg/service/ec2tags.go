@@ -0,0 +1,119 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// defaults of the tags cache
+const (
+	TagsRefresh = 15 * time.Minute
+	TagsRetry   = time.Minute
+)
+
+// ec2EndpointEnv overrides the ec2 api endpoint (i.e. a local stand-in)
+const ec2EndpointEnv = "AWS_EC2_ENDPOINT"
+
+// EC2Tags caches the tags of an instance, read with `ec2:DescribeTags` and falling back to the
+// `tags/instance` category of the instance metadata when the api cannot be used
+type EC2Tags struct {
+	InstanceID string
+	MetaData   EC2MetaData
+	Client     *ec2.EC2
+	Refresh    time.Duration
+
+	mu   sync.Mutex
+	tags map[string]string
+	next time.Time
+}
+
+// NewEC2Tags returns an instance of `EC2Tags` for the instance with id, refreshed every refresh
+func NewEC2Tags(cfg aws.Config, md EC2MetaData, id string, refresh time.Duration) *EC2Tags {
+	if v := os.Getenv(ec2EndpointEnv); v != "" {
+		cfg.EndpointResolver = aws.ResolveWithEndpointURL(v)
+	}
+	if refresh <= 0 {
+		refresh = TagsRefresh
+	}
+	return &EC2Tags{InstanceID: id, MetaData: md, Client: ec2.New(cfg), Refresh: refresh}
+}
+
+// Tags returns the cached tags, they are fetched again once Refresh has elapsed.
+// When fetching fails the previous tags are kept and fetching is tried again after TagsRetry.
+func (t *EC2Tags) Tags() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(t.next) {
+		return t.tags
+	}
+	tags, err := t.describe()
+	if err != nil {
+		log.Printf("tags - describe tags failed, trying instance metadata: %s", err)
+		tags, err = t.metadata()
+	}
+	if err != nil {
+		log.Printf("tags - instance metadata failed: %s", err)
+		t.next = now.Add(TagsRetry)
+		return t.tags
+	}
+	t.tags, t.next = tags, now.Add(t.Refresh)
+	return t.tags
+}
+
+// describe returns the tags of the instance from the ec2 api
+func (t *EC2Tags) describe() (map[string]string, error) {
+	if t.InstanceID == "" {
+		return nil, errors.New("unknown instance id")
+	}
+	input := &ec2.DescribeTagsInput{
+		Filters: []ec2.Filter{{Name: aws.String("resource-id"), Values: []string{t.InstanceID}}},
+	}
+	tags := make(map[string]string)
+	for {
+		out, err := t.Client.DescribeTagsRequest(input).Send()
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range out.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		if aws.StringValue(out.NextToken) == "" {
+			return tags, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// metadata returns the tags of the instance from the instance metadata,
+// which only lists them when access to tags in instance metadata is enabled
+func (t *EC2Tags) metadata() (map[string]string, error) {
+	keys, err := t.MetaData.GetMetadata("tags/instance")
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	// keys are listed one per line and may contain spaces (i.e. Cost Center)
+	for _, key := range strings.Split(keys, "\n") {
+		if key = strings.TrimRight(key, "\r"); key == "" {
+			continue
+		}
+		v, err := t.MetaData.GetMetadata("tags/instance/" + url.PathEscape(key))
+		if err != nil {
+			return nil, err
+		}
+		tags[key] = v
+	}
+	return tags, nil
+}
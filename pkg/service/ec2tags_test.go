@@ -0,0 +1,88 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+)
+
+// describeTags is an httptest stand-in of the ec2 DescribeTags action returning two pages
+func describeTags(t *testing.T, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.PostForm.Get("Action"); got != "DescribeTags" {
+			t.Errorf("action = %q", got)
+		}
+		if got := r.PostForm.Get("Filter.1.Value.1"); got != "i-0123456789abcdef0" {
+			t.Errorf("resource-id filter = %q", got)
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			w.Write([]byte(`<Response><Errors><Error><Code>UnauthorizedOperation</Code><Message>denied</Message></Error></Errors><RequestID>1</RequestID></Response>`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		switch r.PostForm.Get("NextToken") {
+		case "":
+			w.Write([]byte(`<DescribeTagsResponse><requestId>1</requestId><tagSet>` +
+				`<item><resourceId>i-0123456789abcdef0</resourceId><resourceType>instance</resourceType><key>Service</key><value>api</value></item>` +
+				`</tagSet><nextToken>page-2</nextToken></DescribeTagsResponse>`))
+		case "page-2":
+			w.Write([]byte(`<DescribeTagsResponse><requestId>2</requestId><tagSet>` +
+				`<item><resourceId>i-0123456789abcdef0</resourceId><resourceType>instance</resourceType><key>Cost Center</key><value>42</value></item>` +
+				`</tagSet></DescribeTagsResponse>`))
+		default:
+			t.Errorf("unexpected next token %q", r.PostForm.Get("NextToken"))
+		}
+	}))
+}
+
+func newTestTags(t *testing.T, endpoint string, md EC2MetaData) *EC2Tags {
+	os.Setenv(ec2EndpointEnv, endpoint)
+	defer os.Unsetenv(ec2EndpointEnv)
+
+	cfg := defaults.Config()
+	cfg.Region = "eu-west-1"
+	cfg.Credentials = aws.NewStaticCredentialsProvider("AKID", "SECRET", "")
+	cfg.Retryer = aws.DefaultRetryer{NumMaxRetries: 0}
+	return NewEC2Tags(cfg, md, "i-0123456789abcdef0", 0)
+}
+
+func TestEC2TagsDescribe(t *testing.T) {
+	srv := describeTags(t, http.StatusOK)
+	defer srv.Close()
+
+	tags := newTestTags(t, srv.URL, EC2MetaData{}).Tags()
+	want := map[string]string{"Service": "api", "Cost Center": "42"}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+}
+
+func TestEC2TagsMetaData(t *testing.T) {
+	srv := describeTags(t, http.StatusForbidden)
+	defer srv.Close()
+
+	m := &imds{meta: map[string]string{
+		"tags/instance":             "Service\nCost Center",
+		"tags/instance/Service":     "api",
+		"tags/instance/Cost Center": "42",
+	}}
+	md, done := newTestMetaData(m)
+	defer done()
+
+	tags := newTestTags(t, srv.URL, md).Tags()
+	want := map[string]string{"Service": "api", "Cost Center": "42"}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// https://docs.aws.amazon.com/AmazonECS/latest/developerguide/task-metadata-endpoint-v4.html
+const (
+	ECSMetadataEnv     = "ECS_CONTAINER_METADATA_URI_V4"
+	ECSMetadataTimeout = 5 * time.Second
+)
+
+// ECSMetadata reads the task metadata endpoint v4 that ecs exposes to every container of a task
+type ECSMetadata struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+// NewECSMetadata returns an instance of `ECSMetadata` for the endpoint set in the environment of the task
+func NewECSMetadata() (*ECSMetadata, error) {
+	endpoint := os.Getenv(ECSMetadataEnv)
+	if endpoint == "" {
+		return nil, errors.New(ECSMetadataEnv + " is not set, not running in an ecs task")
+	}
+	return &ECSMetadata{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Client:   &http.Client{Timeout: ECSMetadataTimeout},
+	}, nil
+}
+
+// ECSTask is the metadata of the task and its containers
+type ECSTask struct {
+	Cluster     string         `json:"Cluster"`
+	TaskARN     string         `json:"TaskARN"`
+	Family      string         `json:"Family"`
+	Revision    string         `json:"Revision"`
+	ServiceName string         `json:"ServiceName"`
+	LaunchType  string         `json:"LaunchType"`
+	Limits      ECSLimits      `json:"Limits"`
+	Containers  []ECSContainer `json:"Containers"`
+}
+
+// ECSContainer is the metadata of a container of the task
+type ECSContainer struct {
+	DockerID string            `json:"DockerId"`
+	Name     string            `json:"Name"`
+	Image    string            `json:"Image"`
+	Labels   map[string]string `json:"Labels"`
+	Limits   ECSLimits         `json:"Limits"`
+	Type     string            `json:"Type"`
+	Networks []ECSNetwork      `json:"Networks"`
+}
+
+// ECSNetwork is a network a container is attached to
+type ECSNetwork struct {
+	NetworkMode string `json:"NetworkMode"`
+}
+
+// ECSLimits are the cpu units (or vCPUs for a task) and memory in MiB reserved
+type ECSLimits struct {
+	CPU    float64 `json:"CPU"`
+	Memory float64 `json:"Memory"`
+}
+
+// ClusterName returns the name of the cluster, which is given as an arn on ec2
+func (t ECSTask) ClusterName() string {
+	return t.Cluster[strings.LastIndex(t.Cluster, "/")+1:]
+}
+
+// SharedNetwork reports whether the containers of the task share the network interface of the task,
+// which is always the case on fargate, so each of them reports the counters of the whole task
+func (t ECSTask) SharedNetwork() bool {
+	if t.LaunchType == "FARGATE" {
+		return true
+	}
+	for _, c := range t.Containers {
+		for _, n := range c.Networks {
+			if n.NetworkMode == "awsvpc" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TaskID returns the last part of the task arn
+func (t ECSTask) TaskID() string {
+	return t.TaskARN[strings.LastIndex(t.TaskARN, "/")+1:]
+}
+
+// Task returns the metadata of the task
+func (e *ECSMetadata) Task(ctx context.Context) (task ECSTask, err error) {
+	err = e.get(ctx, "/task", &task)
+	return
+}
+
+// Container returns the metadata of the container the endpoint belongs to
+func (e *ECSMetadata) Container(ctx context.Context) (container ECSContainer, err error) {
+	err = e.get(ctx, "", &container)
+	return
+}
+
+// TaskStats returns the docker stats of each container of the task by docker id,
+// a container that is not running has no stats
+func (e *ECSMetadata) TaskStats(ctx context.Context) (stats map[string]*DockerStats, err error) {
+	err = e.get(ctx, "/task/stats", &stats)
+	return
+}
+
+// Stats returns the docker stats of the container the endpoint belongs to
+func (e *ECSMetadata) Stats(ctx context.Context) (stats DockerStats, err error) {
+	err = e.get(ctx, "/stats", &stats)
+	return
+}
+
+// get sends a GET request to the endpoint and decodes the json response into v
+func (e *ECSMetadata) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, e.Endpoint+path, nil)
+	if err != nil {
+		return err
+	}
+	res, err := e.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("ecs metadata %s: %d %s", path, res.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
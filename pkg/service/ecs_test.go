@@ -0,0 +1,98 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// ecsEndpoint is an httptest stand-in of the task metadata endpoint v4
+func ecsEndpoint() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v4/task":
+			w.Write([]byte(`{
+				"Cluster": "arn:aws:ecs:eu-west-1:111122223333:cluster/default",
+				"TaskARN": "arn:aws:ecs:eu-west-1:111122223333:task/default/158d1c8083dd49d6b527399fd6414f5c",
+				"Family": "web", "ServiceName": "web-service", "LaunchType": "EC2",
+				"Limits": {"CPU": 0.25, "Memory": 512},
+				"Containers": [
+					{"DockerId": "app", "Name": "app", "Networks": [{"NetworkMode": "bridge"}]},
+					{"DockerId": "init", "Name": "init", "Networks": [{"NetworkMode": "bridge"}]}
+				]
+			}`))
+		case "/v4/task/stats":
+			// a container that is not running has no stats
+			w.Write([]byte(`{"app": {"memory_stats": {"usage": 1024}}, "init": null}`))
+		case "/v4":
+			w.Write([]byte(`{"DockerId": "app", "Name": "app"}`))
+		case "/v4/stats":
+			w.Write([]byte(`{"memory_stats": {"usage": 1024}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("not found"))
+		}
+	}))
+}
+
+func TestNewECSMetadata(t *testing.T) {
+	os.Unsetenv(ECSMetadataEnv)
+	if _, err := NewECSMetadata(); err == nil {
+		t.Error("expected an error outside of an ecs task")
+	}
+	os.Setenv(ECSMetadataEnv, "http://169.254.170.2/v4/abc/")
+	defer os.Unsetenv(ECSMetadataEnv)
+	e, err := NewECSMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Endpoint != "http://169.254.170.2/v4/abc" {
+		t.Errorf("endpoint = %s", e.Endpoint)
+	}
+}
+
+func TestECSMetadata(t *testing.T) {
+	srv := ecsEndpoint()
+	defer srv.Close()
+	e := &ECSMetadata{Endpoint: srv.URL + "/v4", Client: srv.Client()}
+	ctx := context.Background()
+
+	task, err := e.Task(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ClusterName() != "default" || task.TaskID() != "158d1c8083dd49d6b527399fd6414f5c" {
+		t.Errorf("cluster = %s, task = %s", task.ClusterName(), task.TaskID())
+	}
+	if task.Limits.Memory != 512 || len(task.Containers) != 2 || task.SharedNetwork() {
+		t.Errorf("task = %+v", task)
+	}
+
+	stats, err := e.TaskStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["app"] == nil || stats["app"].MemoryStats.Usage != 1024 || stats["init"] != nil {
+		t.Errorf("task stats = %v", stats)
+	}
+
+	own, err := e.Container(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if own.DockerID != "app" {
+		t.Errorf("container = %+v", own)
+	}
+	if s, err := e.Stats(ctx); err != nil || s.MemoryStats.Usage != 1024 {
+		t.Errorf("stats = %+v, %v", s, err)
+	}
+
+	e.Endpoint = srv.URL + "/v3"
+	if _, err := e.TaskStats(ctx); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+}
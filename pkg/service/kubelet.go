@@ -0,0 +1,188 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// https://kubernetes.io/docs/reference/instrumentation/node-metrics/
+const (
+	KubeletPort    = "10250"
+	KubeletTimeout = 10 * time.Second
+)
+
+// environment of a DaemonSet pod set from the downward api (i.e. `fieldRef: {fieldPath: status.hostIP}`),
+// the kubelet listens on the address of the node, not on the loopback of the pod
+const (
+	HostIPEnv   = "HOST_IP"
+	NodeNameEnv = "NODE_NAME"
+)
+
+// service account credentials mounted in every pod
+const (
+	ServiceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	ServiceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// Kubelet reads the summary and pods apis of the kubelet of a node, authenticated with a service account
+// token which is read again on every request as it is rotated
+type Kubelet struct {
+	Endpoint  string
+	TokenFile string
+	Client    *http.Client
+}
+
+// NewKubelet returns an instance of `Kubelet`, the serving certificate is verified against the cluster ca
+// unless insecure is set (i.e. a kubelet with a self signed certificate),
+// without endpoint the kubelet is reached on HOST_IP or else NODE_NAME
+func NewKubelet(endpoint string, insecure bool) (*Kubelet, error) {
+	if endpoint == "" {
+		endpoint = KubeletNodeEndpoint()
+	}
+	if endpoint == "" {
+		return nil, errors.New("no kubelet endpoint, set it or " + HostIPEnv + " or " + NodeNameEnv + " from the downward api")
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if ca, err := ioutil.ReadFile(ServiceAccountCA); err == nil && !insecure {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		tlsConfig.RootCAs = pool
+	}
+	return &Kubelet{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		TokenFile: ServiceAccountToken,
+		Client:    &http.Client{Timeout: KubeletTimeout, Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}, nil
+}
+
+// KubeletNodeEndpoint returns the endpoint of the kubelet of the node set in the environment, if any
+func KubeletNodeEndpoint() string {
+	for _, env := range []string{HostIPEnv, NodeNameEnv} {
+		if host := strings.TrimSpace(os.Getenv(env)); host != "" {
+			return "https://" + net.JoinHostPort(host, KubeletPort)
+		}
+	}
+	return ""
+}
+
+// KubeletSummary is the usage of the node and of its pods
+type KubeletSummary struct {
+	Pods []KubeletPodStats `json:"pods"`
+}
+
+// KubeletPodStats is the usage of a pod and of its containers
+type KubeletPodStats struct {
+	PodRef struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		UID       string `json:"uid"`
+	} `json:"podRef"`
+	Containers       []KubeletContainerStats `json:"containers"`
+	CPU              *KubeletCPU             `json:"cpu"`
+	Memory           *KubeletMemory          `json:"memory"`
+	Network          *KubeletNetwork         `json:"network"`
+	Volumes          []KubeletVolume         `json:"volume"`
+	EphemeralStorage *KubeletFS              `json:"ephemeral-storage"`
+}
+
+// KubeletContainerStats is the usage of a container
+type KubeletContainerStats struct {
+	Name   string         `json:"name"`
+	CPU    *KubeletCPU    `json:"cpu"`
+	Memory *KubeletMemory `json:"memory"`
+	Rootfs *KubeletFS     `json:"rootfs"`
+	Logs   *KubeletFS     `json:"logs"`
+}
+
+// KubeletCPU is the cpu in use in nano cores and the cumulative cpu time
+type KubeletCPU struct {
+	UsageNanoCores       *uint64 `json:"usageNanoCores"`
+	UsageCoreNanoSeconds *uint64 `json:"usageCoreNanoSeconds"`
+}
+
+// KubeletMemory is the memory in use, the working set is what the kubelet evicts on
+type KubeletMemory struct {
+	UsageBytes      *uint64 `json:"usageBytes"`
+	WorkingSetBytes *uint64 `json:"workingSetBytes"`
+	RSSBytes        *uint64 `json:"rssBytes"`
+}
+
+// KubeletNetwork holds the counters of the default interface of a pod
+type KubeletNetwork struct {
+	RxBytes  *uint64 `json:"rxBytes"`
+	RxErrors *uint64 `json:"rxErrors"`
+	TxBytes  *uint64 `json:"txBytes"`
+	TxErrors *uint64 `json:"txErrors"`
+}
+
+// KubeletFS is the usage of a file system
+type KubeletFS struct {
+	UsedBytes     *uint64 `json:"usedBytes"`
+	CapacityBytes *uint64 `json:"capacityBytes"`
+}
+
+// KubeletVolume is the usage of a volume of a pod
+type KubeletVolume struct {
+	Name string `json:"name"`
+	KubeletFS
+}
+
+// KubeletPodList is the list of the pods running on the node
+type KubeletPodList struct {
+	Items []KubeletPod `json:"items"`
+}
+
+// KubeletPod is the metadata of a pod
+type KubeletPod struct {
+	Metadata struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		UID       string            `json:"uid"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"metadata"`
+}
+
+// Summary returns the usage of the pods of the node
+func (k *Kubelet) Summary(ctx context.Context) (summary KubeletSummary, err error) {
+	err = k.get(ctx, "/stats/summary", &summary)
+	return
+}
+
+// Pods returns the pods of the node
+func (k *Kubelet) Pods(ctx context.Context) (pods KubeletPodList, err error) {
+	err = k.get(ctx, "/pods", &pods)
+	return
+}
+
+// get sends a GET request with the service account token and decodes the json response into v
+func (k *Kubelet) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, k.Endpoint+path, nil)
+	if err != nil {
+		return err
+	}
+	if token, err := ioutil.ReadFile(k.TokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	res, err := k.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("kubelet %s: %d %s", path, res.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
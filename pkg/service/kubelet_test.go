@@ -0,0 +1,179 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const kubeletSummary = `{
+  "node": {"nodeName": "ip-10-0-1-15.ec2.internal"},
+  "pods": [{
+    "podRef": {"name": "web-5d8f", "namespace": "shop", "uid": "8c1f"},
+    "cpu": {"usageNanoCores": 250000000, "usageCoreNanoSeconds": 9000000000},
+    "memory": {"workingSetBytes": 104857600},
+    "network": {"rxBytes": 1024, "txBytes": 2048},
+    "volume": [{"name": "data", "usedBytes": 4096, "capacityBytes": 8192}],
+    "containers": [{"name": "nginx", "cpu": {"usageNanoCores": 200000000}, "logs": {"usedBytes": 512}}]
+  }]
+}`
+
+const kubeletPods = `{
+  "kind": "PodList",
+  "items": [{"metadata": {"name": "web-5d8f", "namespace": "shop", "uid": "8c1f", "labels": {"app": "web"}}}]
+}`
+
+// newTestKubelet returns a kubelet reached over tls on the test server of handler with the given token
+func newTestKubelet(t *testing.T, token string, handler http.HandlerFunc) (*Kubelet, func()) {
+	srv := httptest.NewTLSServer(handler)
+	dir, err := ioutil.TempDir("", "kubelet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := NewKubelet(srv.URL+"/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.TokenFile = filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(k.TokenFile, []byte(token+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return k, func() { srv.Close(); os.RemoveAll(dir) }
+}
+
+func TestKubeletSummary(t *testing.T) {
+	k, done := newTestKubelet(t, "secret", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats/summary" || r.Method != http.MethodGet {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(kubeletSummary))
+	})
+	defer done()
+
+	summary, err := k.Summary(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Pods) != 1 {
+		t.Fatalf("pods = %+v", summary.Pods)
+	}
+	pod := summary.Pods[0]
+	if pod.PodRef.Name != "web-5d8f" || pod.PodRef.Namespace != "shop" || pod.PodRef.UID != "8c1f" {
+		t.Errorf("pod = %+v", pod.PodRef)
+	}
+	if pod.CPU == nil || *pod.CPU.UsageNanoCores != 250000000 || pod.Memory.UsageBytes != nil || *pod.Memory.WorkingSetBytes != 104857600 {
+		t.Errorf("cpu = %+v memory = %+v", pod.CPU, pod.Memory)
+	}
+	if pod.EphemeralStorage != nil || len(pod.Volumes) != 1 || pod.Volumes[0].Name != "data" || *pod.Volumes[0].CapacityBytes != 8192 {
+		t.Errorf("storage = %+v volumes = %+v", pod.EphemeralStorage, pod.Volumes)
+	}
+	if len(pod.Containers) != 1 || pod.Containers[0].Rootfs != nil || *pod.Containers[0].Logs.UsedBytes != 512 {
+		t.Errorf("containers = %+v", pod.Containers)
+	}
+
+	// the token is read again on every request as it is rotated
+	if err := ioutil.WriteFile(k.TokenFile, []byte("rotated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Summary(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("err = %v, want the status of the kubelet", err)
+	}
+}
+
+func TestKubeletPods(t *testing.T) {
+	k, done := newTestKubelet(t, "secret", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pods" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(kubeletPods))
+	})
+	defer done()
+
+	pods, err := k.Pods(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Metadata.Name != "web-5d8f" || pods.Items[0].Metadata.Labels["app"] != "web" {
+		t.Errorf("pods = %+v", pods.Items)
+	}
+}
+
+func TestKubeletErrors(t *testing.T) {
+	k, done := newTestKubelet(t, "secret", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pods":
+			http.Error(w, "Forbidden (user=system:serviceaccount:kube-system:agent, verb=get, resource=nodes, subresource=proxy)", http.StatusForbidden)
+		default:
+			w.Write([]byte("{"))
+		}
+	})
+	defer done()
+
+	if _, err := k.Pods(context.Background()); err == nil || !strings.Contains(err.Error(), "kubelet /pods: 403 Forbidden") {
+		t.Errorf("err = %v, want the status and body of the kubelet", err)
+	}
+	if _, err := k.Summary(context.Background()); err == nil {
+		t.Error("expected an error for a truncated response")
+	}
+
+	// the serving certificate is verified unless insecure is set
+	k.Client.Transport = http.DefaultTransport
+	if _, err := k.Pods(context.Background()); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("err = %v, want a certificate error", err)
+	}
+}
+
+func TestNewKubeletEndpoint(t *testing.T) {
+	restore := func(env string) func() {
+		v, ok := os.LookupEnv(env)
+		return func() {
+			if ok {
+				os.Setenv(env, v)
+			} else {
+				os.Unsetenv(env)
+			}
+		}
+	}
+	defer restore(HostIPEnv)()
+	defer restore(NodeNameEnv)()
+
+	tests := []struct {
+		endpoint string
+		hostIP   string
+		nodeName string
+		want     string
+	}{
+		{"https://10.0.1.15:10250/", "10.0.1.20", "", "https://10.0.1.15:10250"},
+		{"", "10.0.1.20", "ip-10-0-1-20.ec2.internal", "https://10.0.1.20:10250"},
+		{"", "fd00::14", "", "https://[fd00::14]:10250"},
+		{"", "", "ip-10-0-1-20.ec2.internal", "https://ip-10-0-1-20.ec2.internal:10250"},
+		{"", "", "", ""},
+	}
+	for _, tt := range tests {
+		os.Setenv(HostIPEnv, tt.hostIP)
+		os.Setenv(NodeNameEnv, tt.nodeName)
+		k, err := NewKubelet(tt.endpoint, false)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error without endpoint", tt.endpoint)
+			}
+			continue
+		}
+		if err != nil || k.Endpoint != tt.want {
+			t.Errorf("%q with %q and %q: endpoint = %v, %v, want %s", tt.endpoint, tt.hostIP, tt.nodeName, k, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_PutMetricData.html
+const (
+	MaxDatumsPerRequest = 1000
+	MaxBytesPerRequest  = 1000 * 1000
+)
+
+// SpoolReplayBatches is the number of spooled batches replayed after a flush
+const SpoolReplayBatches = 20
+
+// requestOverhead approximates the bytes used by the action, version and namespace parameters
+const requestOverhead = 128
+
+// Publisher buffers metric data per namespace and sends it in batches, retrying failures
+// according to Retry. Batches that still fail are written to the Spool if any and replayed in the
+// background after a successful flush, up to ReplayBatches at a time, batches that can never succeed
+// are dropped.
+// With an Aggregator, data is merged into statistic sets, or values and counts, between flushes.
+type Publisher struct {
+	CloudWatch CloudWatch
+	Spool      *Spool
+	Retry      Retry
+	Stats      *Stats
+	Aggregator *Aggregator
+
+	ReplayBatches int
+
+	mu        sync.Mutex
+	order     []string
+	buffer    map[string][]Datum
+	replaying int32
+	replays   sync.WaitGroup
+}
+
+// NewPublisher returns an instance of `Publisher`, spool may be nil
+func NewPublisher(cw CloudWatch, spool *Spool, retry Retry) *Publisher {
+	return &Publisher{
+		CloudWatch: cw,
+		Spool:      spool,
+		Retry:      retry,
+		Stats:      retry.Stats,
+		buffer:     make(map[string][]Datum),
+
+		ReplayBatches: SpoolReplayBatches,
+	}
+}
+
+// Publish buffers metric data until the next call to Flush
+func (p *Publisher) Publish(data []Datum, namespace string) {
+	if p.Aggregator != nil {
+		p.Aggregator.Add(data, namespace)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.buffer[namespace]; !ok {
+		p.order = append(p.order, namespace)
+	}
+	p.buffer[namespace] = append(p.buffer[namespace], data...)
+}
+
+// Flush sends all buffered metric data, one request per batch and namespace
+func (p *Publisher) Flush() {
+	p.mu.Lock()
+	order, buffer := p.order, p.buffer
+	p.order, p.buffer = nil, make(map[string][]Datum)
+	p.mu.Unlock()
+
+	if p.Aggregator != nil {
+		order, buffer = p.Aggregator.Drain()
+	}
+
+	// after a batch gave up on a retryable error (i.e. network outage) the remaining batches are
+	// spooled without being sent, so a flush does not block collections for several retry periods
+	var failed bool
+	var outage error
+	for _, namespace := range order {
+		var sent int
+		batches := Batches(buffer[namespace])
+		for _, b := range batches {
+			if outage != nil {
+				p.giveUp(b, namespace, outage)
+				continue
+			}
+			if err := p.send(b, namespace); err != nil {
+				log.Printf("publish to %s failed: %s", namespace, err)
+				p.giveUp(b, namespace, err)
+				failed = true
+				if Retryable(err) {
+					outage = err
+				}
+				continue
+			}
+			sent += len(b)
+		}
+		log.Printf("published %d of %d datums in %d requests to %s", sent, len(buffer[namespace]), len(batches), namespace)
+	}
+
+	if failed || p.Spool == nil {
+		return
+	}
+	p.replay()
+}
+
+// replay sends spooled batches in the background unless a replay is still running,
+// so a large backlog after an outage does not delay the next collections
+func (p *Publisher) replay() {
+	if !atomic.CompareAndSwapInt32(&p.replaying, 0, 1) {
+		return
+	}
+	p.replays.Add(1)
+	go func() {
+		defer p.replays.Done()
+		defer atomic.StoreInt32(&p.replaying, 0)
+		if n, err := p.Spool.Replay(p.send, p.ReplayBatches); n > 0 || err != nil {
+			log.Printf("replayed %d spooled batches, error: %v", n, err)
+		}
+	}()
+}
+
+// Wait returns once the running replay, if any, is over
+func (p *Publisher) Wait() {
+	p.replays.Wait()
+}
+
+// send publishes a batch with retries
+func (p *Publisher) send(data []Datum, namespace string) error {
+	return p.Retry.Do(func() error {
+		return p.CloudWatch.Publish(data, namespace)
+	})
+}
+
+// giveUp persists a batch that could not be sent, it is dropped when there is no spool
+// or when sending it again cannot succeed
+func (p *Publisher) giveUp(data []Datum, namespace string, err error) {
+	if p.Spool == nil || !Retryable(err) {
+		log.Printf("dropping %d datums for %s", len(data), namespace)
+		p.Stats.add(&p.Stats.Dropped, int64(len(data)))
+		return
+	}
+	if err := p.Spool.Write(namespace, data); err != nil {
+		log.Printf("dropping %d datums for %s, spool failed: %s", len(data), namespace, err)
+		p.Stats.add(&p.Stats.Dropped, int64(len(data)))
+		return
+	}
+	p.Stats.add(&p.Stats.Spooled, int64(len(data)))
+}
+
+// Batches splits data into chunks that stay within the PutMetricData limits
+func Batches(data []Datum) (batches [][]Datum) {
+	var start, size = 0, requestOverhead
+	for i, d := range data {
+		n := datumSize(i-start+1, d)
+		if i > start && (i-start == MaxDatumsPerRequest || size+n > MaxBytesPerRequest) {
+			batches = append(batches, data[start:i])
+			start, size = i, requestOverhead
+			n = datumSize(1, d)
+		}
+		size += n
+	}
+	if start < len(data) {
+		batches = append(batches, data[start:])
+	}
+	return
+}
+
+// datumSize approximates the url encoded size of a datum at position n of a request
+func datumSize(n int, d Datum) int {
+	prefix := "MetricData.member." + strconv.Itoa(n) + "."
+	field := func(key, value string) int {
+		return len(prefix) + len(key) + len(url.QueryEscape(value)) + 2
+	}
+	size := field("Unit", string(d.Unit))
+	if d.MetricName != nil {
+		size += field("MetricName", *d.MetricName)
+	}
+	if d.Value != nil {
+		size += field("Value", strconv.FormatFloat(*d.Value, 'g', -1, 64))
+	}
+	if d.Timestamp != nil {
+		size += field("Timestamp", d.Timestamp.Format("2006-01-02T15:04:05Z"))
+	}
+	if d.StorageResolution != nil {
+		size += field("StorageResolution", strconv.FormatInt(*d.StorageResolution, 10))
+	}
+	if s := d.StatisticValues; s != nil {
+		size += 4 * field("StatisticValues.SampleCount", "-1.7976931348623157e+308")
+	}
+	for i, v := range d.Values {
+		size += field("Values.member."+strconv.Itoa(i+1), strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	for i, c := range d.Counts {
+		size += field("Counts.member."+strconv.Itoa(i+1), strconv.FormatFloat(c, 'g', -1, 64))
+	}
+	for i, dim := range d.Dimensions {
+		key := "Dimensions.member." + strconv.Itoa(i+1) + "."
+		if dim.Name != nil {
+			size += field(key+"Name", *dim.Name)
+		}
+		if dim.Value != nil {
+			size += field(key+"Value", *dim.Value)
+		}
+	}
+	return size
+}
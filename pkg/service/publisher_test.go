@@ -0,0 +1,187 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+)
+
+func TestPublisherReplay(t *testing.T) {
+	// the stand-in holds replayed requests until released
+	var mu sync.Mutex
+	var namespaces []string
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		ns := r.PostForm.Get("Namespace")
+		if ns == "spooled" {
+			<-release
+		}
+		mu.Lock()
+		namespaces = append(namespaces, ns)
+		mu.Unlock()
+		w.Write([]byte(putMetricDataResponse))
+	}))
+	defer srv.Close()
+
+	spool, done := newTestSpool(t, 0, 0)
+	defer done()
+	now := time.Now().UTC()
+	for i := 0; i < 3; i++ {
+		if err := spool.Write("spooled", []Datum{value(float64(i), now)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := NewPublisher(testCloudWatch(srv.URL), spool, Retry{Stats: &Stats{}})
+	p.ReplayBatches = 2
+
+	// the flush returns while the replay is held, and batches can still be spooled
+	p.Publish([]Datum{value(1, now)}, "live")
+	flushed := make(chan struct{})
+	go func() {
+		p.Flush()
+		p.Publish([]Datum{value(2, now)}, "live")
+		p.Flush() // a replay is running, no other is started
+		if err := spool.Write("spooled", []Datum{value(3, now)}); err != nil {
+			t.Error(err)
+		}
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("flush waited for the replay")
+	}
+
+	close(release)
+	p.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	var live, spooled int
+	for _, ns := range namespaces {
+		switch ns {
+		case "live":
+			live++
+		case "spooled":
+			spooled++
+		}
+	}
+	if live != 2 || spooled != 2 {
+		t.Errorf("sent %d live and %d spooled batches, want 2 and at most ReplayBatches", live, spooled)
+	}
+	if files, _ := spool.files(); len(files) != 2 {
+		t.Errorf("%d batches left in the spool, want 2", len(files))
+	}
+}
+
+func TestBatchesCount(t *testing.T) {
+	now := time.Now().UTC()
+	data := func(n int) (out []Datum) {
+		for i := 0; i < n; i++ {
+			out = append(out, value(float64(i), now))
+		}
+		return
+	}
+	tests := []struct {
+		datums int
+		want   []int
+	}{
+		{0, nil},
+		{1, []int{1}},
+		{MaxDatumsPerRequest - 1, []int{MaxDatumsPerRequest - 1}},
+		{MaxDatumsPerRequest, []int{MaxDatumsPerRequest}},
+		{MaxDatumsPerRequest + 1, []int{MaxDatumsPerRequest, 1}},
+		{2*MaxDatumsPerRequest + 5, []int{MaxDatumsPerRequest, MaxDatumsPerRequest, 5}},
+	}
+	for _, tt := range tests {
+		in := data(tt.datums)
+		var got []int
+		var next int
+		for _, b := range Batches(in) {
+			got = append(got, len(b))
+			// batches keep the order of the data
+			if aws.Float64Value(b[0].Value) != float64(next) {
+				t.Errorf("%d datums: batch starts at %v, want %d", tt.datums, *b[0].Value, next)
+			}
+			next += len(b)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d datums: batches of %v, want %v", tt.datums, got, tt.want)
+		}
+	}
+}
+
+func TestBatchesSize(t *testing.T) {
+	// datums with 10 dimensions of 250 characters use about 5kB each, so the payload limit is
+	// reached well before 1000 datums
+	now := time.Now().UTC()
+	var data []Datum
+	for i := 0; i < 500; i++ {
+		d := value(float64(i), now)
+		d.Dimensions = nil
+		for j := 0; j < 10; j++ {
+			d.Dimensions = append(d.Dimensions, cloudwatch.Dimension{
+				Name:  aws.String(fmt.Sprintf("d%d", j)),
+				Value: aws.String(strings.Repeat("v", 250)),
+			})
+		}
+		data = append(data, d)
+	}
+
+	batches := Batches(data)
+	if len(batches) < 2 {
+		t.Fatalf("got %d batches, want the payload split", len(batches))
+	}
+	var total int
+	for i, b := range batches {
+		size := requestOverhead
+		for n, d := range b {
+			size += datumSize(n+1, d)
+		}
+		if size > MaxBytesPerRequest {
+			t.Errorf("batch %d: %d bytes, above %d", i, size, MaxBytesPerRequest)
+		}
+		// a batch is only cut when the next datum would not fit
+		if i < len(batches)-1 && size+datumSize(len(b)+1, batches[i+1][0]) <= MaxBytesPerRequest {
+			t.Errorf("batch %d: cut at %d bytes while the next datum fits", i, size)
+		}
+		total += len(b)
+	}
+	if total != len(data) {
+		t.Errorf("batched %d datums, want %d", total, len(data))
+	}
+
+	// a datum alone above the limit still gets a batch of its own
+	huge := value(1, now)
+	huge.Dimensions[0].Value = aws.String(strings.Repeat("v", MaxBytesPerRequest))
+	if got := Batches([]Datum{value(0, now), huge, value(2, now)}); len(got) != 3 {
+		t.Errorf("got %d batches, want 3", len(got))
+	}
+}
+
+func TestDatumSize(t *testing.T) {
+	// the size of a datum grows with its position in the request
+	d := value(1, time.Now())
+	if datumSize(1000, d) <= datumSize(1, d) {
+		t.Error("datum size does not account for its position")
+	}
+	set := d
+	set.Value = nil
+	set.StatisticValues = &cloudwatch.StatisticSet{
+		SampleCount: aws.Float64(1), Sum: aws.Float64(1), Minimum: aws.Float64(1), Maximum: aws.Float64(1),
+	}
+	if datumSize(1, set) <= datumSize(1, d) {
+		t.Error("datum size does not account for the statistic set")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+// defaults of the retry policy
+const (
+	RetryBase       = 200 * time.Millisecond
+	RetryMaxDelay   = 20 * time.Second
+	RetryMaxElapsed = time.Minute
+	RetryMaxTPS     = 20
+)
+
+// Retry sends a request again after a retryable failure, waiting with exponential backoff and full jitter
+type Retry struct {
+	Base       time.Duration
+	MaxDelay   time.Duration
+	MaxElapsed time.Duration
+	Limiter    *TokenBucket
+	Stats      *Stats
+}
+
+// NewRetry returns an instance of `Retry` giving up after maxElapsed and sending at most maxTPS requests per second
+func NewRetry(maxElapsed time.Duration, maxTPS float64, stats *Stats) Retry {
+	return Retry{
+		Base:       RetryBase,
+		MaxDelay:   RetryMaxDelay,
+		MaxElapsed: maxElapsed,
+		Limiter:    NewTokenBucket(maxTPS, maxTPS),
+		Stats:      stats,
+	}
+}
+
+// Do calls send until it succeeds, fails with an error that is not retryable or runs out of time
+func (r Retry) Do(send func() error) (err error) {
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		if r.Limiter != nil {
+			r.Limiter.Wait()
+		}
+		r.Stats.add(&r.Stats.Requests, 1)
+		if err = send(); err == nil || !Retryable(err) {
+			return err
+		}
+		if aws.IsErrorThrottle(err) {
+			r.Stats.add(&r.Stats.Throttles, 1)
+		}
+
+		delay := r.backoff(attempt)
+		if time.Since(start)+delay > r.MaxElapsed {
+			return err
+		}
+		r.Stats.add(&r.Stats.Retries, 1)
+		time.Sleep(delay)
+	}
+}
+
+// backoff returns a random delay up to Base * 2^attempt, capped by MaxDelay
+func (r Retry) backoff(attempt int) time.Duration {
+	ceiling := r.MaxDelay
+	if attempt < 30 {
+		if d := r.Base << uint(attempt); d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Retryable reports whether sending again may succeed: throttling, server errors,
+// expired credentials and connection failures are retryable, client errors are not
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if aws.IsErrorRetryable(err) || aws.IsErrorThrottle(err) || aws.IsErrorExpiredCreds(err) {
+		return true
+	}
+	if rf, ok := err.(awserr.RequestFailure); ok {
+		return rf.StatusCode() >= http.StatusInternalServerError || rf.StatusCode() == http.StatusTooManyRequests
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		err = aerr.OrigErr()
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// TokenBucket limits the rate of requests, holding at most Burst tokens refilled at Rate per second
+type TokenBucket struct {
+	Rate  float64
+	Burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full instance of `TokenBucket`, a rate of zero or less disables the limit
+func NewTokenBucket(rate, burst float64) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{Rate: rate, Burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Wait blocks until a token is available and takes it
+func (t *TokenBucket) Wait() {
+	if t.Rate <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.Rate
+	if t.tokens > t.Burst {
+		t.tokens = t.Burst
+	}
+	t.last = now
+
+	t.tokens--
+	if t.tokens < 0 {
+		wait := time.Duration(-t.tokens / t.Rate * float64(time.Second))
+		time.Sleep(wait)
+		t.last = t.last.Add(wait)
+		t.tokens = 0
+	}
+}
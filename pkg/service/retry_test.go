@@ -0,0 +1,162 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+// timeout is a net.Error such as a dial or read timeout
+type timeout struct{}
+
+func (timeout) Error() string   { return "i/o timeout" }
+func (timeout) Timeout() bool   { return true }
+func (timeout) Temporary() bool { return true }
+
+var _ net.Error = timeout{}
+
+func TestRetryable(t *testing.T) {
+	failure := func(code string, status int) error {
+		return awserr.NewRequestFailure(awserr.New(code, "message", nil), status, "id")
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"throttling", failure("Throttling", http.StatusBadRequest), true},
+		{"request limit", failure("RequestLimitExceeded", http.StatusBadRequest), true},
+		{"too many requests", failure("TooManyRequests", http.StatusTooManyRequests), true},
+		{"server error", failure("InternalFailure", http.StatusInternalServerError), true},
+		{"unavailable", failure("ServiceUnavailable", http.StatusServiceUnavailable), true},
+		{"expired credentials", failure("ExpiredToken", http.StatusForbidden), true},
+		{"invalid parameter", failure("InvalidParameterValue", http.StatusBadRequest), false},
+		{"denied", failure("AccessDenied", http.StatusForbidden), false},
+		{"payload too large", failure("RequestEntityTooLarge", http.StatusRequestEntityTooLarge), false},
+		{"connection", awserr.New("RequestError", "send request failed", timeout{}), true},
+		{"net error", timeout{}, true},
+		{"other", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("%s: retryable = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	r := Retry{Base: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{40, time.Second},
+		{70, time.Second},
+	}
+	for _, tt := range tests {
+		var max time.Duration
+		for i := 0; i < 200; i++ {
+			d := r.backoff(tt.attempt)
+			if d < 0 || d > tt.ceiling {
+				t.Fatalf("attempt %d: delay %s outside [0, %s]", tt.attempt, d, tt.ceiling)
+			}
+			if d > max {
+				max = d
+			}
+		}
+		// full jitter spreads the delays over the whole range
+		if max < tt.ceiling/2 {
+			t.Errorf("attempt %d: delays up to %s, want them spread up to %s", tt.attempt, max, tt.ceiling)
+		}
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	throttled := awserr.NewRequestFailure(awserr.New("Throttling", "rate exceeded", nil), http.StatusBadRequest, "id")
+	invalid := awserr.NewRequestFailure(awserr.New("InvalidParameterValue", "bad", nil), http.StatusBadRequest, "id")
+	tests := []struct {
+		name     string
+		errs     []error
+		elapsed  time.Duration
+		want     error
+		requests int64
+		retries  int64
+		throttle int64
+	}{
+		{"success", []error{nil}, time.Minute, nil, 1, 0, 0},
+		{"retried until success", []error{throttled, timeout{}, nil}, time.Minute, nil, 3, 2, 1},
+		{"not retryable", []error{invalid, nil}, time.Minute, invalid, 1, 0, 0},
+		{"out of time", []error{throttled, nil}, time.Nanosecond, throttled, 1, 0, 1},
+	}
+	for _, tt := range tests {
+		stats := &Stats{}
+		r := Retry{Base: time.Millisecond, MaxDelay: time.Millisecond, MaxElapsed: tt.elapsed, Stats: stats}
+		if tt.elapsed < time.Millisecond {
+			// the next delay is almost certainly beyond the time left
+			r.Base, r.MaxDelay = time.Hour, time.Hour
+		}
+		var calls int
+		err := r.Do(func() error {
+			err := tt.errs[calls]
+			calls++
+			return err
+		})
+		if err != tt.want {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+		if got := stats.Take(); got.Requests != tt.requests || got.Retries != tt.retries || got.Throttles != tt.throttle {
+			t.Errorf("%s: stats = %+v, want %d requests %d retries %d throttles", tt.name, got, tt.requests, tt.retries, tt.throttle)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	// a burst is served at once
+	b := NewTokenBucket(100, 5)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		b.Wait()
+	}
+	if d := time.Since(start); d > 20*time.Millisecond {
+		t.Errorf("burst of 5 took %s", d)
+	}
+
+	// then tokens are refilled at the rate
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		b.Wait()
+	}
+	if d := time.Since(start); d < 40*time.Millisecond || d > time.Second {
+		t.Errorf("5 tokens at 100 per second took %s, want about 50ms", d)
+	}
+
+	// idle time refills the bucket up to the burst only
+	b.mu.Lock()
+	b.last = b.last.Add(-time.Hour)
+	b.mu.Unlock()
+	b.Wait()
+	if b.tokens != b.Burst-1 {
+		t.Errorf("tokens = %v, want %v after a long idle time", b.tokens, b.Burst-1)
+	}
+
+	// a rate of zero disables the limit and a burst below 1 holds a single token
+	start = time.Now()
+	free := NewTokenBucket(0, 0)
+	for i := 0; i < 1000; i++ {
+		free.Wait()
+	}
+	if d := time.Since(start); d > 100*time.Millisecond || free.Burst != 1 {
+		t.Errorf("unlimited bucket took %s with burst %v", d, free.Burst)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpoolMaxAge is the age after which cloud watch rejects metric data, less a margin for the send itself
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_PutMetricData.html
+const SpoolMaxAge = 14*24*time.Hour - time.Hour
+
+// spoolExt is the extension of the files holding a batch
+const spoolExt = ".json"
+
+// Spool persists batches that could not be sent so they can be replayed once cloud watch is reachable
+type Spool struct {
+	Dir      string
+	MaxBytes int64
+	MaxAge   time.Duration
+
+	mu  sync.Mutex
+	seq int
+}
+
+// spooled is the content of a spool file
+type spooled struct {
+	Namespace string  `json:"namespace"`
+	Data      []Datum `json:"data"`
+}
+
+// NewSpool returns an instance of `Spool` writing to dir, which is created if needed
+func NewSpool(dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if maxAge <= 0 || maxAge > SpoolMaxAge {
+		maxAge = SpoolMaxAge
+	}
+	return &Spool{Dir: dir, MaxBytes: maxBytes, MaxAge: maxAge}, nil
+}
+
+// Write persists a batch, data without a timestamp is stamped now so it keeps its original time
+func (s *Spool) Write(namespace string, data []Datum) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	for i := range data {
+		if data[i].Timestamp == nil {
+			data[i].Timestamp = &now
+		}
+	}
+	b, err := json.Marshal(spooled{Namespace: namespace, Data: data})
+	if err != nil {
+		return err
+	}
+
+	// names sort in the order batches were written
+	s.seq++
+	name := filepath.Join(s.Dir, fmt.Sprintf("%020d-%06d%s", now.UnixNano(), s.seq%1000000, spoolExt))
+	if err := ioutil.WriteFile(name+".tmp", b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+
+	return s.trim()
+}
+
+// Replay sends up to max spooled batches oldest first, all of them when max is 0, and removes them
+// once sent. It stops at the first failure so the remaining batches are kept for later.
+// Batches may be written while a replay is sending, one trimmed meanwhile is skipped.
+func (s *Spool) Replay(send func([]Datum, string) error, max int) (sent int, err error) {
+	s.mu.Lock()
+	files, err := s.files()
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	oldest := time.Now().Add(-s.MaxAge)
+	for _, f := range files {
+		if max > 0 && sent >= max {
+			break
+		}
+		var batch spooled
+		b, err := ioutil.ReadFile(f.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = json.Unmarshal(b, &batch)
+		}
+		if err != nil {
+			log.Printf("spool - dropping unreadable %s: %s", f.path, err)
+			os.Remove(f.path)
+			continue
+		}
+
+		var data []Datum
+		for _, d := range batch.Data {
+			if d.Timestamp != nil && d.Timestamp.Before(oldest) {
+				continue
+			}
+			data = append(data, d)
+		}
+		if dropped := len(batch.Data) - len(data); dropped > 0 {
+			log.Printf("spool - dropping %d datums older than %s", dropped, s.MaxAge)
+		}
+
+		if len(data) > 0 {
+			if err := send(data, batch.Namespace); err != nil {
+				return sent, err
+			}
+			sent++
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// spoolFile is a batch on disk
+type spoolFile struct {
+	path string
+	size int64
+}
+
+// files returns the spooled batches, oldest first
+func (s *Spool) files() (files []spoolFile, err error) {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range infos {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), spoolExt) {
+			continue
+		}
+		files = append(files, spoolFile{path: filepath.Join(s.Dir, fi.Name()), size: fi.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
+
+// trim removes the oldest batches until the spool fits within MaxBytes
+func (s *Spool) trim() error {
+	if s.MaxBytes <= 0 {
+		return nil
+	}
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	for _, f := range files {
+		if total <= s.MaxBytes {
+			break
+		}
+		log.Printf("spool - size above %d bytes, dropping %s", s.MaxBytes, f.path)
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+		total -= f.size
+	}
+	return nil
+}
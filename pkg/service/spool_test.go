@@ -0,0 +1,180 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+)
+
+// newTestSpool returns a spool in a temporary directory, removed by the returned func
+func newTestSpool(t *testing.T, maxBytes int64, maxAge time.Duration) (*Spool, func()) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSpool(filepath.Join(dir, "spool"), maxBytes, maxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+// replayed records the batches sent by a replay
+type replayed struct {
+	namespaces []string
+	values     [][]float64
+	fail       int // the replay fails from the fail-th batch, 0 for never
+}
+
+func (r *replayed) send(data []Datum, namespace string) error {
+	if r.fail > 0 && len(r.namespaces)+1 >= r.fail {
+		return errors.New("unreachable")
+	}
+	var values []float64
+	for _, d := range data {
+		values = append(values, aws.Float64Value(d.Value))
+	}
+	r.namespaces = append(r.namespaces, namespace)
+	r.values = append(r.values, values)
+	return nil
+}
+
+func TestSpoolReplay(t *testing.T) {
+	s, done := newTestSpool(t, 0, 0)
+	defer done()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, ns := range []string{"a", "b", "a"} {
+		if err := s.Write(ns, []Datum{value(float64(i), now), value(float64(10+i), now)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// oldest first, at most max at a time
+	var r replayed
+	n, err := s.Replay(r.send, 2)
+	if err != nil || n != 2 {
+		t.Fatalf("replayed %d, %v, want 2", n, err)
+	}
+	if !reflect.DeepEqual(r.namespaces, []string{"a", "b"}) || !reflect.DeepEqual(r.values, [][]float64{{0, 10}, {1, 11}}) {
+		t.Errorf("replayed %v %v", r.namespaces, r.values)
+	}
+
+	n, err = s.Replay(r.send, 0)
+	if err != nil || n != 1 || !reflect.DeepEqual(r.values[2], []float64{2, 12}) {
+		t.Errorf("replayed %d, %v: %v", n, err, r.values)
+	}
+	if n, _ := s.Replay(r.send, 0); n != 0 {
+		t.Errorf("replayed %d batches from an empty spool", n)
+	}
+}
+
+func TestSpoolReplayFailure(t *testing.T) {
+	s, done := newTestSpool(t, 0, 0)
+	defer done()
+
+	now := time.Now().UTC()
+	for i := 0; i < 3; i++ {
+		if err := s.Write("a", []Datum{value(float64(i), now)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the failing batch and those after it are kept for later
+	r := replayed{fail: 2}
+	if n, err := s.Replay(r.send, 0); err == nil || n != 1 {
+		t.Errorf("replayed %d, %v, want 1 and an error", n, err)
+	}
+	r = replayed{}
+	if n, err := s.Replay(r.send, 0); err != nil || n != 2 || !reflect.DeepEqual(r.values, [][]float64{{1}, {2}}) {
+		t.Errorf("replayed %d, %v: %v", n, err, r.values)
+	}
+}
+
+func TestSpoolWrite(t *testing.T) {
+	s, done := newTestSpool(t, 0, time.Hour)
+	defer done()
+
+	// data without timestamp keeps the time it was spooled at, old data is dropped on replay
+	before := time.Now()
+	d := Datum{MetricDatum: cloudwatch.MetricDatum{MetricName: aws.String("cpu"), Value: aws.Float64(1)}}
+	if err := s.Write("a", []Datum{d, value(2, time.Now().Add(-2*time.Hour))}); err != nil {
+		t.Fatal(err)
+	}
+	var got []Datum
+	n, err := s.Replay(func(data []Datum, _ string) error { got = data; return nil }, 0)
+	if err != nil || n != 1 || len(got) != 1 {
+		t.Fatalf("replayed %d, %v: %+v", n, err, got)
+	}
+	if got[0].Timestamp == nil || got[0].Timestamp.Before(before.Add(-time.Second)) {
+		t.Errorf("timestamp = %v, want the time it was spooled", got[0].Timestamp)
+	}
+
+	// distributions survive the round trip
+	dist := distribution([]float64{1, 2}, []float64{3, 4}, time.Now().UTC())
+	if err := s.Write("a", []Datum{dist}); err != nil {
+		t.Fatal(err)
+	}
+	s.Replay(func(data []Datum, _ string) error { got = data; return nil }, 0)
+	if !reflect.DeepEqual(got[0].Values, dist.Values) || !reflect.DeepEqual(got[0].Counts, dist.Counts) {
+		t.Errorf("distribution = %+v, want %+v", got[0], dist)
+	}
+
+	// a file that cannot be read is dropped
+	if err := ioutil.WriteFile(filepath.Join(s.Dir, "0-broken"+spoolExt), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Replay(func([]Datum, string) error { return nil }, 0); n != 0 || err != nil {
+		t.Errorf("replayed %d, %v", n, err)
+	}
+	if files, _ := s.files(); len(files) != 0 {
+		t.Errorf("files = %v, want none", files)
+	}
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	s, done := newTestSpool(t, 0, 0)
+	defer done()
+
+	now := time.Now().UTC()
+	if err := s.Write("a", []Datum{value(0, now)}); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := s.files()
+	size := files[0].size
+
+	// room for two batches, the oldest are dropped first
+	s.MaxBytes = 2*size + size/2
+	for i := 1; i < 4; i++ {
+		if err := s.Write("a", []Datum{value(float64(i), now)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var r replayed
+	if _, err := s.Replay(r.send, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.values, [][]float64{{2}, {3}}) {
+		t.Errorf("kept %v, want the two newest batches", r.values)
+	}
+}
+
+func TestNewSpool(t *testing.T) {
+	s, done := newTestSpool(t, 0, 30*24*time.Hour)
+	defer done()
+	if s.MaxAge != SpoolMaxAge {
+		t.Errorf("max age = %s, want at most %s", s.MaxAge, SpoolMaxAge)
+	}
+	if fi, err := os.Stat(s.Dir); err != nil || !fi.IsDir() {
+		t.Errorf("spool directory not created: %v", err)
+	}
+}
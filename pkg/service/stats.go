@@ -0,0 +1,32 @@
+// Copyright © 2018 Sylvester La-Tunje. All rights reserved.
+
+package service
+
+import (
+	"sync/atomic"
+)
+
+// Stats counts what happened to the metric data sent to cloud watch, safe for concurrent use
+type Stats struct {
+	Requests  int64
+	Retries   int64
+	Throttles int64
+	Spooled   int64
+	Dropped   int64
+}
+
+// Take returns the counts since the previous call and resets them
+func (s *Stats) Take() Stats {
+	return Stats{
+		Requests:  atomic.SwapInt64(&s.Requests, 0),
+		Retries:   atomic.SwapInt64(&s.Retries, 0),
+		Throttles: atomic.SwapInt64(&s.Throttles, 0),
+		Spooled:   atomic.SwapInt64(&s.Spooled, 0),
+		Dropped:   atomic.SwapInt64(&s.Dropped, 0),
+	}
+}
+
+// add increments a counter of s
+func (s *Stats) add(counter *int64, n int64) {
+	atomic.AddInt64(counter, n)
+}
@@ -2,6 +2,11 @@
 
 package utils
 
+import (
+	"strconv"
+	"time"
+)
+
 // ExitXXX represents various exit code within the system
 const (
 	_ = iota
@@ -12,17 +17,83 @@ const (
 	ExitShareConfigFailure
 	ExitBase64DecodeFailure
 	ExitOnDebug
+	ExitConfigFailure
 )
 
 const (
 	CWARegion    = "eu-west-1"
 	CWANamespace = "CustomMetrics"
-	CWAInterval  = 5
+	CWAInterval  = "5m"
+	CWACounters  = "rate"
+	CWAIdentity  = "auto"
+	CWASpoolDir  = "/var/lib/cwametric/spool"
+	CWASpoolSize = 64
+)
+
+const (
+	CWARegionKey        = "aws_cwa_region"
+	CWANamespaceKey     = "aws_cwa_namespace"
+	CWAIntervalKey      = "aws_cwa_interval"
+	CWAOnceKey          = "aws_cwa_once"
+	CWACountersKey      = "aws_cwa_counters"
+	CWACounterKey       = "aws_cwa_counter"
+	CWAIdentityKey      = "aws_cwa_identity"
+	CWAAlignKey         = "aws_cwa_align"
+	CWAFlushIntervalKey = "aws_cwa_flush_interval"
+)
+
+const (
+	CWASpoolDirKey    = "aws_cwa_spool_dir"
+	CWASpoolSizeKey   = "aws_cwa_spool_size"
+	CWASpoolMaxAgeKey = "aws_cwa_spool_max_age"
+)
+
+const (
+	CWARetryMaxElapsedKey = "aws_cwa_retry_max_elapsed"
+	CWAMaxTPSKey          = "aws_cwa_max_tps"
+)
+
+const (
+	CWAIMDSTokenTTLKey = "aws_cwa_imds_token_ttl"
+	CWAIMDSv1Key       = "aws_cwa_imds_v1"
+	CWATagsRefreshKey  = "aws_cwa_tags_refresh"
 )
 
 const (
-	CWARegionKey    = "aws_cwa_region"
-	CWANamespaceKey = "aws_cwa_namespace"
-	CWAIntervalKey  = "aws_cwa_interval"
-	CWAOnceKey      = "aws_cwa_once"
+	CWACPUPerCoreKey = "aws_cwa_cpu_percore"
+	CWACPUTotalKey   = "aws_cwa_cpu_total"
 )
+
+const (
+	CWANetPerInterfaceKey = "aws_cwa_net_per_interface"
+	CWANetTotalKey        = "aws_cwa_net_total"
+)
+
+const (
+	CWADockerBackendKey = "aws_cwa_docker_backend"
+	CWADockerSocketKey  = "aws_cwa_docker_socket"
+	CWADockerLabelsKey  = "aws_cwa_docker_labels"
+)
+
+const (
+	CWAKubeletKey         = "aws_cwa_kubelet"
+	CWAKubeletInsecureKey = "aws_cwa_kubelet_insecure"
+	CWAClusterNameKey     = "aws_cwa_cluster_name"
+)
+
+const (
+	CWAProcstatExeKey  = "aws_cwa_procstat_exe"
+	CWANetstatPortsKey = "aws_cwa_netstat_ports"
+)
+
+// HighResolution is the interval below which metrics are stored at a one second resolution
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/publishingMetrics.html#high-resolution-metrics
+const HighResolution = time.Minute
+
+// ParseInterval parses a duration such as 10s or 5m, a bare number is a number of minutes
+func ParseInterval(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Minute, nil
+	}
+	return time.ParseDuration(s)
+}